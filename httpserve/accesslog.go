@@ -0,0 +1,227 @@
+package httpserve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of access log lines
+const (
+	FormatJSON     = "json"     // One JSON object per line
+	FormatCombined = "combined" // Apache combined log format
+)
+
+// AccessLog is a middleware writing a line for every request to a writer.
+type AccessLog struct {
+	format string
+	mu     sync.Mutex // Serializes writes of lines
+	w      io.Writer
+}
+
+// NewAccessLog returns an AccessLog writing lines in the given format (one
+// of the Format constants) to the given writer.
+func NewAccessLog(w io.Writer, format string) (*AccessLog, error) {
+	if format != FormatJSON && format != FormatCombined {
+		return nil, fmt.Errorf("unknown access log format '%s' (want %s or %s)",
+			format, FormatJSON, FormatCombined)
+	}
+	return &AccessLog{format: format, w: w}, nil
+}
+
+// OpenAccessLog returns an AccessLog writing lines in the given format to
+// the named file, rotated once it would exceed maxSize bytes with the given
+// number of old files kept (see RotatingFile), or to standard output if
+// the name is "-". Close must be called once the log is no longer needed.
+func OpenAccessLog(name, format string, maxSize int64, keep int) (*AccessLog, error) {
+	if name == "-" {
+		return NewAccessLog(os.Stdout, format)
+	}
+
+	f, err := OpenRotatingFile(name, maxSize, keep)
+	if err != nil {
+		return nil, err
+	}
+	l, err := NewAccessLog(f, format)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Close closes the writer of the log, if it is an io.Closer other than
+// standard output.
+func (l *AccessLog) Close() error {
+	if c, ok := l.w.(io.Closer); ok && l.w != io.Writer(os.Stdout) {
+		return c.Close()
+	}
+	return nil
+}
+
+// accessRecord holds what is logged about a request
+type accessRecord struct {
+	Time       time.Time         `json:"time"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"` // With any query
+	Proto      string            `json:"proto"`
+	Host       string            `json:"host"`
+	Status     int               `json:"status"`
+	Bytes      int64             `json:"bytes"`
+	LatencyMs  float64           `json:"latency_ms"`
+	RemoteAddr string            `json:"remote_addr"`
+	Referer    string            `json:"referer,omitempty"`
+	UserAgent  string            `json:"user_agent,omitempty"`
+	Fields     map[string]string `json:"fields,omitempty"` // See SetLogField
+}
+
+// fieldsKey is the context key of the fields of a request being logged
+type fieldsKey struct{}
+
+// logFields holds the fields set on a request being logged
+type logFields struct {
+	mu     sync.Mutex
+	fields map[string]string
+}
+
+// SetLogField adds a field, such as the short path a request matched, to
+// the access log line of the request. It does nothing if the request is
+// not being logged by an AccessLog.
+func SetLogField(r *http.Request, key, value string) {
+	lf, ok := r.Context().Value(fieldsKey{}).(*logFields)
+	if !ok {
+		return
+	}
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.fields == nil {
+		lf.fields = make(map[string]string)
+	}
+	lf.fields[key] = value
+}
+
+// Handler returns a handler logging every request served by the given one.
+// JSON lines have the fields of accessRecord. Combined lines are followed
+// by the latency in milliseconds and any fields set with SetLogField, as
+// key="value" pairs.
+func (l *AccessLog) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lf := &logFields{}
+		lw := &loggingWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r.WithContext(context.WithValue(r.Context(),
+			fieldsKey{}, lf)))
+
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+		remote := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(remote); err == nil {
+			remote = host
+		}
+		lf.mu.Lock()
+		fields := lf.fields
+		lf.mu.Unlock()
+
+		l.write(accessRecord{
+			Time:       start,
+			Method:     r.Method,
+			Path:       r.URL.RequestURI(),
+			Proto:      r.Proto,
+			Host:       r.Host,
+			Status:     lw.status,
+			Bytes:      lw.bytes,
+			LatencyMs:  float64(time.Since(start).Microseconds()) / 1000,
+			RemoteAddr: remote,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+			Fields:     fields,
+		})
+	})
+}
+
+// write writes the record as a line in the log's format
+func (l *AccessLog) write(rec accessRecord) {
+	var line []byte
+	if l.format == FormatJSON {
+		line, _ = json.Marshal(rec)
+	} else {
+		line = combinedLine(rec)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(append(line, '\n'))
+}
+
+// combinedLine returns the record in the Apache combined log format,
+// followed by its latency and fields
+func combinedLine(rec accessRecord) []byte {
+	var sb strings.Builder
+	bytes := "-"
+	if rec.Bytes > 0 {
+		bytes = strconv.FormatInt(rec.Bytes, 10)
+	}
+	fmt.Fprintf(&sb, `%s - - [%s] "%s %s %s" %d %s "%s" "%s" %.3f`,
+		rec.RemoteAddr, rec.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		rec.Method, rec.Path, rec.Proto, rec.Status, bytes,
+		quoteless(rec.Referer), quoteless(rec.UserAgent), rec.LatencyMs)
+
+	keys := make([]string, 0, len(rec.Fields))
+	for k := range rec.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&sb, ` %s="%s"`, k, quoteless(rec.Fields[k]))
+	}
+	return []byte(sb.String())
+}
+
+// quoteless returns the string with quotes and backslashes escaped, or "-"
+// if it is empty, as Apache logs strings
+func quoteless(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// loggingWriter records the status and size of a response
+type loggingWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (lw *loggingWriter) WriteHeader(status int) {
+	if lw.status == 0 {
+		lw.status = status
+	}
+	lw.ResponseWriter.WriteHeader(status)
+}
+
+func (lw *loggingWriter) Write(b []byte) (int, error) {
+	if lw.status == 0 {
+		lw.status = http.StatusOK
+	}
+	n, err := lw.ResponseWriter.Write(b)
+	lw.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the writer
+func (lw *loggingWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,143 @@
+package httpserve
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// loggedHandler sets fields on the requests it serves, as surl does
+var loggedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	SetLogField(r, "short", "/docs")
+	SetLogField(r, "note", `say "hi"`)
+	w.WriteHeader(http.StatusFound)
+	w.Write([]byte("moved"))
+})
+
+// loggedRequest returns a request with everything an access log records
+func loggedRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "http://go.example.com/docs?x=1", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("Referer", "https://example.com/")
+	r.Header.Set("User-Agent", "test/1.0")
+	return r
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := NewAccessLog(&buf, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Handler(loggedHandler).ServeHTTP(httptest.NewRecorder(), loggedRequest())
+
+	var rec accessRecord
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("line %q is not JSON: %v", buf.String(), err)
+	}
+	if !strings.HasSuffix(buf.String(), "}\n") || strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("line %q is not a single line", buf.String())
+	}
+	if rec.Time.IsZero() || rec.LatencyMs < 0 {
+		t.Errorf("time %v, latency %v not recorded", rec.Time, rec.LatencyMs)
+	}
+	rec.LatencyMs = 0
+	want := accessRecord{
+		Time:       rec.Time,
+		Method:     "GET",
+		Path:       "/docs?x=1",
+		Proto:      "HTTP/1.1",
+		Host:       "go.example.com",
+		Status:     http.StatusFound,
+		Bytes:      5,
+		RemoteAddr: "192.0.2.1",
+		Referer:    "https://example.com/",
+		UserAgent:  "test/1.0",
+		Fields:     map[string]string{"short": "/docs", "note": `say "hi"`},
+	}
+	got, _ := json.Marshal(rec)
+	wantJSON, _ := json.Marshal(want)
+	if !bytes.Equal(got, wantJSON) {
+		t.Errorf("logged %s\nwant %s", got, wantJSON)
+	}
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := NewAccessLog(&buf, FormatCombined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Handler(loggedHandler).ServeHTTP(httptest.NewRecorder(), loggedRequest())
+
+	// Empty responses and headers are logged as "-"
+	l.Handler(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodHead, "/missing", nil))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	patterns := []string{
+		`^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}\] ` +
+			`"GET /docs\?x=1 HTTP/1\.1" 302 5 "https://example\.com/" "test/1\.0" ` +
+			`\d+\.\d{3} note="say \\"hi\\"" short="/docs"$`,
+		`^192\.0\.2\.1 - - \[[^]]+\] "HEAD /missing HTTP/1\.1" 404 \d+ "-" "-" \d+\.\d{3}$`,
+	}
+	if len(lines) != len(patterns) {
+		t.Fatalf("logged %d lines, want %d:\n%s", len(lines), len(patterns), buf.String())
+	}
+	for i, pattern := range patterns {
+		if !regexp.MustCompile(pattern).MatchString(lines[i]) {
+			t.Errorf("line %d = %q\nwant match for %s", i+1, lines[i], pattern)
+		}
+	}
+}
+
+func TestSetLogFieldNotLogged(t *testing.T) {
+	// Without an AccessLog there is nowhere to put the field
+	rec := httptest.NewRecorder()
+	loggedHandler.ServeHTTP(rec, loggedRequest())
+	if rec.Code != http.StatusFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusFound)
+	}
+}
+
+func TestOpenAccessLog(t *testing.T) {
+	if _, err := NewAccessLog(ioutil.Discard, "common"); err == nil {
+		t.Error("NewAccessLog() accepted an unknown format")
+	}
+
+	name := filepath.Join(t.TempDir(), "access.log")
+	if _, err := OpenAccessLog(name, "common", 1<<20, 1); err == nil {
+		t.Error("OpenAccessLog() accepted an unknown format")
+	}
+	l, err := OpenAccessLog(name, FormatJSON, 1<<20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		l.Handler(loggedHandler).ServeHTTP(httptest.NewRecorder(), loggedRequest())
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("file has %d lines, want 3:\n%s", len(lines), data)
+	}
+	for _, line := range lines {
+		var rec accessRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil || rec.Path != "/docs?x=1" {
+			t.Errorf("line %q: %+v, %v", line, rec, err)
+		}
+	}
+}
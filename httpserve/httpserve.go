@@ -0,0 +1,145 @@
+/*
+Package httpserve runs the web servers of this repository (surl and sbook)
+the same way: on an http.Server that shuts down gracefully on SIGINT or
+SIGTERM, with /healthz and /readyz endpoints for load balancers and
+orchestrators, logging requests to an access log if asked to.
+*/
+package httpserve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Time allowed by default for requests in flight to finish on shutdown
+const DefaultDrainTimeout = 15 * time.Second
+
+// Health tracks whether a server is ready for requests, for its /readyz
+// endpoint. A server is ready once SetReady(true) has been called, until
+// it starts shutting down, as long as all of its checks pass.
+type Health struct {
+	ready  int32 // Accessed atomically
+	mu     sync.Mutex
+	checks []namedCheck
+}
+
+// namedCheck is a readiness check along with its name
+type namedCheck struct {
+	name  string
+	check func() error
+}
+
+// SetReady marks the server ready for requests, or not.
+func (h *Health) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&h.ready, v)
+}
+
+// AddCheck adds a check that must return nil for the server to be ready,
+// such as whether its data loaded successfully.
+func (h *Health) AddCheck(name string, check func() error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, namedCheck{name, check})
+}
+
+// Ready returns nil if the server is ready, else the reason it is not.
+func (h *Health) Ready() error {
+	if atomic.LoadInt32(&h.ready) == 0 {
+		return errors.New("not ready")
+	}
+
+	h.mu.Lock()
+	checks := h.checks
+	h.mu.Unlock()
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			return fmt.Errorf("%s: %v", c.name, err)
+		}
+	}
+	return nil
+}
+
+// Register adds the /healthz endpoint, which responds 200 as long as the
+// server is running, and the /readyz endpoint, which responds 200 if the
+// server is ready and 503 with the reason if not, to the given mux.
+func (h *Health) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := h.Ready(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// Run serves requests with the given server until the process receives
+// SIGINT or SIGTERM, then marks the health not ready (if given) and shuts
+// the server down, allowing requests in flight up to the drain timeout to
+// finish. The serve function starts the server, as with ListenAndServe
+// (used if it is nil) or ListenAndServeTLS. Run returns nil once the
+// server has shut down cleanly, else the error serving or shutting down.
+func Run(srv *http.Server, serve func() error, health *Health,
+	drain time.Duration) error {
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	return RunWithSignals(srv, serve, health, drain, sigs)
+}
+
+// RunWithSignals is the same as Run but shuts the server down on a signal
+// from the given channel instead of the process's SIGINT or SIGTERM.
+func RunWithSignals(srv *http.Server, serve func() error, health *Health,
+	drain time.Duration, sigs <-chan os.Signal) error {
+
+	if serve == nil {
+		serve = srv.ListenAndServe
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- serve()
+	}()
+
+	select {
+	case err := <-errc:
+		return err // Failed to start, most likely
+	case sig := <-sigs:
+		log.Printf("received %v, shutting down (waiting up to %v)", sig, drain)
+	}
+
+	if health != nil {
+		health.SetReady(false)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		return fmt.Errorf("error shutting down: %v", err)
+	}
+	if err := <-errc; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package httpserve
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	var failing error
+	tests := []struct {
+		name       string
+		ready      bool
+		failing    error
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"healthz not ready", false, nil, "/healthz", http.StatusOK, "ok\n"},
+		{"healthz failing check", true, errors.New("down"), "/healthz", http.StatusOK, "ok\n"},
+		{"readyz not ready", false, nil, "/readyz", http.StatusServiceUnavailable,
+			"not ready\n"},
+		{"readyz ready", true, nil, "/readyz", http.StatusOK, "ok\n"},
+		{"readyz failing check", true, errors.New("not loaded"), "/readyz",
+			http.StatusServiceUnavailable, "store: not loaded\n"},
+	}
+
+	var health Health
+	health.AddCheck("store", func() error { return failing })
+	mux := http.NewServeMux()
+	health.Register(mux)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health.SetReady(tt.ready)
+			failing = tt.failing
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus || rec.Body.String() != tt.wantBody {
+				t.Errorf("GET %s = %d %q, want %d %q", tt.path, rec.Code,
+					rec.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", got)
+			}
+		})
+	}
+}
+
+func TestRunFinishesInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("finished"))
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var health Health
+	health.SetReady(true)
+	sigs := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- RunWithSignals(srv, func() error { return srv.Serve(ln) }, &health,
+			5*time.Second, sigs)
+	}()
+
+	type response struct {
+		body string
+		err  error
+	}
+	resc := make(chan response, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			resc <- response{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		resc <- response{string(body), err}
+	}()
+
+	<-started
+	sigs <- syscall.SIGTERM
+	for health.Ready() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Run() returned %v with a request in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if res := <-resc; res.err != nil || res.body != "finished" {
+		t.Errorf("in flight request got %q, %v; want it to finish", res.body, res.err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Run() = %v, want nil", err)
+	}
+	if err := health.Ready(); err == nil || !strings.Contains(err.Error(), "not ready") {
+		t.Errorf("Ready() after shutdown = %v, want not ready", err)
+	}
+}
+
+func TestRunServeError(t *testing.T) {
+	want := errors.New("address in use")
+	err := RunWithSignals(&http.Server{}, func() error { return want }, nil, time.Second,
+		make(chan os.Signal))
+	if err != want {
+		t.Errorf("Run() = %v, want %v", err, want)
+	}
+}
@@ -0,0 +1,93 @@
+package httpserve
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is an io.Writer appending to a file that is rotated once it
+// would grow beyond a maximum size: the file is renamed with a .1 suffix
+// (older files moving on to .2, .3 and so on, up to the number kept) and
+// a new file started.
+type RotatingFile struct {
+	name    string
+	maxSize int64
+	keep    int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens the named file for appending (creating it if
+// necessary), to be rotated when it would exceed maxSize bytes, keeping
+// the given number of rotated files. Close must be called once the file is
+// no longer needed.
+func OpenRotatingFile(name string, maxSize int64, keep int) (*RotatingFile, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("maximum size of %s must be positive", name)
+	}
+	f := &RotatingFile{name: name, maxSize: maxSize, keep: keep}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// open opens the file for appending, noting its current size
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// rotate closes the file, shifts it and the files rotated before along by
+// one (dropping the oldest) and opens a new file
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	if f.keep > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", f.name, f.keep))
+		for i := f.keep - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.name, i), fmt.Sprintf("%s.%d", f.name, i+1))
+		}
+		if err := os.Rename(f.name, f.name+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.name); err != nil {
+		return err
+	}
+	return f.open()
+}
@@ -0,0 +1,103 @@
+package httpserve
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		keep      int
+		writes    int
+		wantFiles map[string]string // By suffix
+	}{
+		{"no rotation", 2, 2, map[string]string{"": "line 1\nline 2\n"}},
+		{"rotated once", 2, 3, map[string]string{
+			"":   "line 3\n",
+			".1": "line 1\nline 2\n",
+		}},
+		{"oldest dropped", 2, 7, map[string]string{
+			"":   "line 7\n",
+			".1": "line 5\nline 6\n",
+			".2": "line 3\nline 4\n",
+		}},
+		{"none kept", 0, 5, map[string]string{"": "line 5\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			name := filepath.Join(dir, "access.log")
+			// Room for two lines
+			f, err := OpenRotatingFile(name, int64(2*len("line 1\n")), tt.keep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 1; i <= tt.writes; i++ {
+				if _, err := fmt.Fprintf(f, "line %d\n", i); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != len(tt.wantFiles) {
+				var names []string
+				for _, fi := range files {
+					names = append(names, fi.Name())
+				}
+				t.Errorf("files = %v, want %d", names, len(tt.wantFiles))
+			}
+			for suffix, want := range tt.wantFiles {
+				data, err := ioutil.ReadFile(name + suffix)
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				if string(data) != want {
+					t.Errorf("access.log%s = %q, want %q", suffix, data, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "access.log")
+	if err := ioutil.WriteFile(name, []byte("old 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The size of the existing file counts towards the maximum
+	f, err := OpenRotatingFile(name, int64(2*len("old 1\n")), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(f, "new 1")
+	fmt.Fprintln(f, "new 2")
+	f.Close()
+
+	for suffix, want := range map[string]string{"": "new 2\n", ".1": "old 1\nnew 1\n"} {
+		data, err := ioutil.ReadFile(name + suffix)
+		if err != nil || string(data) != want {
+			t.Errorf("access.log%s = %q, %v; want %q", suffix, data, err, want)
+		}
+	}
+
+	if _, err := OpenRotatingFile(name, 0, 1); err == nil ||
+		!strings.Contains(err.Error(), "must be positive") {
+		t.Errorf("OpenRotatingFile() with no maximum size error = %v", err)
+	}
+	if _, err := os.Stat(name + ".2"); !os.IsNotExist(err) {
+		t.Errorf("access.log.2 exists with one file kept")
+	}
+}
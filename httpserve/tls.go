@@ -0,0 +1,149 @@
+package httpserve
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long generated self-signed certificates are valid for
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// SelfSignedCert makes sure the given certificate and key files hold a
+// self-signed certificate for all the given hostnames (or IP addresses)
+// that is valid for at least another day, generating a new one if not.
+// Generated certificates are cached in the files, so they are reused by
+// later runs and only have to be trusted once.
+func SelfSignedCert(certFile, keyFile string, hosts []string) error {
+	if len(hosts) == 0 {
+		return fmt.Errorf("no hostnames for the self-signed certificate")
+	}
+	if cachedCertCovers(certFile, keyFile, hosts) {
+		return nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, certPEM, 0644)
+}
+
+// cachedCertCovers returns whether the given files hold a matching
+// certificate and key, with the certificate valid for all the hostnames
+// for at least another day
+func cachedCertCovers(certFile, keyFile string, hosts []string) bool {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return false
+	}
+	if time.Now().Add(24 * time.Hour).After(cert.NotAfter) {
+		return false
+	}
+	for _, h := range hosts {
+		if cert.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// RedirectToHTTPS returns a handler redirecting every request to the same
+// URL over HTTPS, on the port of the given HTTPS address (left out if it
+// is the default port 443).
+func RedirectToHTTPS(httpsAddr string) (http.Handler, error) {
+	_, port, err := net.SplitHostPort(httpsAddr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return nil, fmt.Errorf("port of %s is not a number", httpsAddr)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6
+		}
+		// Permanent, but keeping the method and body of the request
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	}), nil
+}
+
+// HSTS returns a handler adding a Strict-Transport-Security header with
+// the given max age to responses of the given handler over HTTPS, telling
+// browsers to only use HTTPS for the host (and its subdomains if asked to)
+// from then on.
+func HSTS(next http.Handler, maxAge time.Duration, subdomains bool) http.Handler {
+	value := fmt.Sprintf("max-age=%d", int64(maxAge/time.Second))
+	if subdomains {
+		value += "; includeSubDomains"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
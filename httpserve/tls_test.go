@@ -0,0 +1,138 @@
+package httpserve
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSelfSignedCert(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	readCert := func() []byte {
+		t.Helper()
+		data, err := ioutil.ReadFile(certFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	if err := SelfSignedCert(certFile, keyFile, nil); err == nil {
+		t.Error("SelfSignedCert() accepted no hostnames")
+	}
+
+	hosts := []string{"go.example.com", "127.0.0.1"}
+	if err := SelfSignedCert(certFile, keyFile, hosts); err != nil {
+		t.Fatal(err)
+	}
+	first := readCert()
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		t.Fatalf("generated files do not load: %v", err)
+	}
+	if !cachedCertCovers(certFile, keyFile, hosts) {
+		t.Error("generated certificate does not cover its hosts")
+	}
+
+	tests := []struct {
+		name  string
+		hosts []string
+		reuse bool
+	}{
+		{"same hosts", hosts, true},
+		{"fewer hosts", []string{"127.0.0.1"}, true},
+		{"new host", []string{"go.example.com", "links.example.com"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := readCert()
+			if err := SelfSignedCert(certFile, keyFile, tt.hosts); err != nil {
+				t.Fatal(err)
+			}
+			if reused := bytes.Equal(readCert(), before); reused != tt.reuse {
+				t.Errorf("certificate reused = %v, want %v", reused, tt.reuse)
+			}
+			if !cachedCertCovers(certFile, keyFile, tt.hosts) {
+				t.Error("certificate does not cover the hosts")
+			}
+		})
+	}
+	if cachedCertCovers(certFile, keyFile, hosts) {
+		t.Error("regenerated certificate still covers 127.0.0.1")
+	}
+	if bytes.Equal(readCert(), first) {
+		t.Error("certificate never regenerated")
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		addr   string
+		target string
+		want   string
+	}{
+		{":443", "http://go.example.com/docs?x=1", "https://go.example.com/docs?x=1"},
+		{":443", "http://go.example.com:8080/docs", "https://go.example.com/docs"},
+		{"0.0.0.0:8443", "http://go.example.com/docs", "https://go.example.com:8443/docs"},
+		{":8443", "http://go.example.com:8080/a%2Fb", "https://go.example.com:8443/a%2Fb"},
+		{":8443", "http://[::1]:8080/docs", "https://[::1]:8443/docs"},
+		{":8443", "http://[::1]/docs", "https://[::1]:8443/docs"},
+		{":443", "http://[::1]:8080/docs", "https://[::1]/docs"},
+	}
+	for _, tt := range tests {
+		h, err := RedirectToHTTPS(tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.target, nil))
+		if rec.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s via %s: status %d, want %d", tt.target, tt.addr, rec.Code,
+				http.StatusPermanentRedirect)
+		}
+		if got := rec.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s via %s: redirected to %s, want %s", tt.target, tt.addr, got, tt.want)
+		}
+	}
+
+	for _, addr := range []string{"8443", ":https"} {
+		if _, err := RedirectToHTTPS(addr); err == nil {
+			t.Errorf("RedirectToHTTPS(%q) accepted the address", addr)
+		}
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	tests := []struct {
+		name       string
+		tls        bool
+		subdomains bool
+		want       string
+	}{
+		{"plain http", false, true, ""},
+		{"tls", true, false, "max-age=86400"},
+		{"tls with subdomains", true, true, "max-age=86400; includeSubDomains"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := HSTS(http.NotFoundHandler(), 24*time.Hour, tt.subdomains)
+			r := httptest.NewRequest(http.MethodGet, "http://go.example.com/", nil)
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if got := rec.Header().Get("Strict-Transport-Security"); got != tt.want {
+				t.Errorf("Strict-Transport-Security = %q, want %q", got, tt.want)
+			}
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("status %d, want the wrapped handler's", rec.Code)
+			}
+		})
+	}
+}
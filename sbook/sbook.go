@@ -22,6 +22,7 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"log"
 	"fmt"
 	"strings"
@@ -29,6 +30,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"html/template"
+	"github.com/go_practice/httpserve"
 )
 
 const bookFile string = "Book.json"
@@ -52,6 +54,24 @@ var tmpl *template.Template // Global template
 
 const storyURL = "/story"
 
+var (
+	accessLog	string
+	logFormat	string
+	logMaxSize	int64
+	logKeep		int
+)
+
+func init() {
+	flag.StringVar(&accessLog, "access-log", "",
+		"a file to log requests to ('-' for standard output)")
+	flag.StringVar(&logFormat, "access-log-format", httpserve.FormatJSON,
+		"the format of access log lines: json or combined")
+	flag.Int64Var(&logMaxSize, "access-log-max-mb", 100,
+		"size in megabytes at which the access log file is rotated")
+	flag.IntVar(&logKeep, "access-log-keep", 5,
+		"number of rotated access log files kept")
+}
+
 const tmplText = `
 <!DOCTYPE html>
 <html>
@@ -101,6 +121,7 @@ func storyHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	flag.Parse()
 	book, err := ioutil.ReadFile(bookFile)
 	if err != nil {
 		log.Fatal(errors.New(fmt.Sprintf("error reading book file %s: ",
@@ -137,6 +158,33 @@ func main() {
 	http.HandleFunc("/", storyHandler)
 	http.HandleFunc(storyURL, storyHandler)
 
+	// Ready once the book is loaded, as long as it has an intro to start at
+	readiness := &httpserve.Health{}
+	readiness.AddCheck("book", func() error {
+		if _, ok := arcsMap["intro"]; !ok {
+			return errors.New("no story arc 'intro'")
+		}
+		return nil
+	})
+	readiness.Register(http.DefaultServeMux)
+	readiness.SetReady(true)
+
+	// Logging every request, if asked to
+	var handler http.Handler = http.DefaultServeMux
+	if accessLog != "" {
+		l, err := httpserve.OpenAccessLog(accessLog, logFormat,
+			logMaxSize<<20, logKeep)
+		if err != nil {
+			log.Fatalf("-access-log: %v", err)
+		}
+		defer l.Close()
+		handler = l.Handler(handler)
+	}
+
+	srv := &http.Server{Addr: ":8080", Handler: handler}
 	fmt.Println("Starting story server at 8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	err = httpserve.Run(srv, nil, readiness, httpserve.DefaultDrainTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
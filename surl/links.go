@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go_practice/urlshort"
+)
+
+////
+// Offline link management: surl [-yaml|-json|-store file] add|rm|ls|mv|validate
+////
+
+// Usage of each of the link subcommands, after their flags
+var linkUsage = map[string]string{
+	"add":      "path url",
+	"rm":       "path...",
+	"ls":       "",
+	"mv":       "path new-path",
+	"validate": "",
+}
+
+// links runs the given link subcommand on the file given by the -yaml,
+// -json or -store flag, editing it in place for add, rm and mv. Paths may
+// be preceded by a host, as in go.example.com/some-path. It returns the
+// exit status: 0 on success, 1 if the edit is refused (or validate finds
+// problems) and 2 on a usage or parse error
+func links(cmd string, args []string) int {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the output as json")
+	var add urlshort.Entry
+	var expires string
+	if cmd == "add" {
+		fs.StringVar(&add.Description, "desc", "", "a description of the link")
+		fs.IntVar(&add.Status, "status", 0, "the redirect status (303 if not set)")
+		fs.StringVar(&expires, "expires", "", "when the link expires (RFC 3339)")
+		fs.IntVar(&add.MaxUses, "max-uses", 0, "uses after which the link expires")
+		fs.BoolVar(&add.Interstitial, "interstitial", false,
+			"show the preview page instead of redirecting")
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: surl [-yaml|-json|-store file] %s [flags] %s\n",
+			cmd, linkUsage[cmd])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	args = fs.Args()
+	if want := len(strings.Fields(linkUsage[cmd])); len(args) != want &&
+		!(cmd == "rm" && len(args) > 0) {
+
+		fs.Usage()
+		return 2
+	}
+
+	fileName, ext, err := linkFileName()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if cmd == "validate" {
+		return validate(fileName, ext, *asJSON)
+	}
+	f, err := urlshort.OpenLinkFileAs(fileName, ext)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var changed []urlshort.Entry
+	switch cmd {
+	case "ls":
+		return printEntries(os.Stdout, f.Entries(), *asJSON)
+	case "add":
+		add.Host, add.Path = urlshort.SplitKey(args[0])
+		add.URL = args[1]
+		if expires != "" {
+			t, err := time.Parse(time.RFC3339, expires)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "-expires: %v\n", err)
+				return 2
+			}
+			add.ExpiresAt = &t
+		}
+		now := time.Now().UTC().Truncate(time.Second)
+		add.Created = &now
+		if err = f.Add(add); err != nil {
+			err = fmt.Errorf("%s: %v", args[0], err)
+		} else if err = checkValid(f, args[0]); err == nil {
+			changed = append(changed, add)
+		}
+	case "rm":
+		for _, key := range args {
+			e, _ := f.Lookup(key)
+			if err = f.Remove(key); err != nil {
+				err = fmt.Errorf("%s: %v", key, err)
+				break
+			}
+			changed = append(changed, e)
+		}
+	case "mv":
+		if err = f.Move(args[0], args[1]); err == nil {
+			if err = checkValid(f, args[1]); err == nil {
+				e, _ := f.Lookup(args[1])
+				changed = append(changed, e)
+			}
+		} else if err == urlshort.ErrEntryNotFound {
+			err = fmt.Errorf("%s: %v", args[0], err)
+		} else {
+			err = fmt.Errorf("%s: %v", args[1], err)
+		}
+	}
+	if err == nil {
+		err = f.Save()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return printEntries(os.Stdout, changed, *asJSON)
+}
+
+// linkFileName returns the one file given by the -yaml, -json or -store
+// flag, for the link subcommands, along with the extension of the format
+// the server reads it in: JSON for -json and -store, and that of its own
+// extension for -yaml
+func linkFileName() (string, string, error) {
+	var name, ext string
+	given := 0
+	for _, f := range []struct{ name, ext string }{
+		{yamlFile, filepath.Ext(yamlFile)},
+		{jsonFile, ".json"},
+		{storeFile, ".json"},
+	} {
+		if f.name != "" {
+			name, ext = f.name, f.ext
+			given++
+		}
+	}
+	if given != 1 {
+		return "", "", fmt.Errorf("give the file of links with one of -yaml, -json or -store")
+	}
+	return name, ext, nil
+}
+
+// checkValid returns an error listing the problems Validate finds with the
+// entry with the given key in the file, if any
+func checkValid(f *urlshort.LinkFile, key string) error {
+	entries := f.Entries()
+	e, _ := f.Lookup(key)
+	var msgs []string
+	for _, p := range urlshort.Validate(entries) {
+		if entries[p.Entry].Key() == e.Key() {
+			msgs = append(msgs, p.Kind+": "+p.Message)
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("%s: %s", key, strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// printEntries prints the entries as a table or as json, returning exit
+// status 0
+func printEntries(w io.Writer, entries []urlshort.Entry, asJSON bool) int {
+	if asJSON {
+		if entries == nil {
+			entries = []urlshort.Entry{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(entries)
+		return 0
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tURL\tSTATUS\tEXPIRES\tUSES\tDESCRIPTION")
+	for _, e := range entries {
+		status, expires, uses := "-", "-", strconv.Itoa(e.Uses)
+		if e.Status != 0 {
+			status = strconv.Itoa(e.Status)
+		}
+		if e.ExpiresAt != nil {
+			expires = e.ExpiresAt.Format(time.RFC3339)
+		}
+		if e.MaxUses > 0 {
+			uses += "/" + strconv.Itoa(e.MaxUses)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Key(), e.URL, status,
+			expires, uses, e.Description)
+	}
+	tw.Flush()
+	return 0
+}
@@ -11,41 +11,534 @@ shortener would.
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"github.com/go_practice/httpserve"
 	"github.com/go_practice/urlshort"
 )
 
-func main() {
-	mux := defaultMux()
+var (
+	yamlFile	string
+	jsonFile	string
+	storeFile	string
+	addr		string
+	fallback	string
+	validateOnly	bool
+	adminTokens	string
+	rate		float64
+	burst		int
+	trustedHops	int
+	healthEvery	time.Duration
+	sweepEvery	time.Duration
+	historyFile	string
+	drain		time.Duration
+	accessLog	string
+	logFormat	string
+	logMaxSize	int64
+	logKeep		int
+	tlsCert		string
+	tlsKey		string
+	selfSigned	bool
+	tlsHosts	string
+	httpAddr	string
+	hsts		time.Duration
+	hstsSubdomains	bool
+)
 
-	// Build the MapHandler using the mux as the fallback
-	pathsToUrls := map[string]string{
-		"/urlshort-godoc": "https://godoc.org/github.com/gophercises/urlshort",
-		"/yaml-godoc":     "https://godoc.org/gopkg.in/yaml.v2",
-	}
-	mapHandler := urlshort.MapHandler(pathsToUrls, mux)
+// Entries served when no -yaml, -json or -store file is given, from a map
+// and inline yaml
+var pathsToUrls = map[string]string{
+	"/urlshort-godoc": "https://godoc.org/github.com/gophercises/urlshort",
+	"/yaml-godoc":     "https://godoc.org/gopkg.in/yaml.v2",
+}
 
-	// Build the YAMLHandler using the mapHandler as the
-	// fallback
-	yaml := `
+// First path segments of the endpoints served under them, ahead of the links
+var endpointCodes = []string{"api", "admin", "qr"}
+
+// Codes the creation API refuses: those of the endpoints, including the
+// health and readiness ones, which no link could be served under
+var reservedCodes = append([]string{"metrics", "healthz", "readyz"}, endpointCodes...)
+
+const inlineYAML = `
 - path: /urlshort
   url: https://github.com/gophercises/urlshort
 - path: /urlshort-final
   url: https://github.com/gophercises/urlshort/tree/solution
 `
-	yamlHandler, err := urlshort.YAMLHandler([]byte(yaml), mapHandler)
+
+func init() {
+	flag.StringVar(&yamlFile, "yaml", "",
+		"a yaml file of path/url entries, reloaded whenever it changes")
+	flag.StringVar(&jsonFile, "json", "",
+		"a json file of path/url entries, reloaded whenever it changes")
+	flag.StringVar(&storeFile, "store", "",
+		"a json file keeping the links created through the APIs")
+	flag.StringVar(&addr, "addr", ":8080",
+		"the address to listen on")
+	flag.StringVar(&tlsCert, "tls-cert", "",
+		"a certificate file to serve HTTPS with (along with -tls-key)")
+	flag.StringVar(&tlsKey, "tls-key", "",
+		"the private key file of the -tls-cert certificate")
+	flag.BoolVar(&selfSigned, "tls-self-signed", false,
+		"serve HTTPS with a self-signed certificate for -tls-hosts, generated once and cached")
+	flag.StringVar(&tlsHosts, "tls-hosts", "localhost,127.0.0.1",
+		"comma separated hostnames and IP addresses of the self-signed certificate")
+	flag.StringVar(&httpAddr, "http-addr", "",
+		"an address to redirect plain HTTP requests to HTTPS from, when serving HTTPS")
+	flag.DurationVar(&hsts, "hsts", 0,
+		"max age of the HSTS header sent over HTTPS (0 for none)")
+	flag.BoolVar(&hstsSubdomains, "hsts-subdomains", false,
+		"include subdomains in the HSTS header")
+	flag.StringVar(&fallback, "fallback", "404",
+		"what unknown paths get: hello, 404 (suggesting paths) or a URL to redirect to")
+	flag.DurationVar(&drain, "drain", httpserve.DefaultDrainTimeout,
+		"time allowed for requests in flight to finish on shutdown")
+	flag.StringVar(&accessLog, "access-log", "",
+		"a file to log requests to ('-' for standard output)")
+	flag.StringVar(&logFormat, "access-log-format", httpserve.FormatJSON,
+		"the format of access log lines: json or combined")
+	flag.Int64Var(&logMaxSize, "access-log-max-mb", 100,
+		"size in megabytes at which the access log file is rotated")
+	flag.IntVar(&logKeep, "access-log-keep", 5,
+		"number of rotated access log files kept")
+	flag.BoolVar(&validateOnly, "validate", false,
+		"report problems with the entries of the yaml file and exit")
+	flag.StringVar(&adminTokens, "admin-tokens", "",
+		"a file of 'token read|write [author]' lines enabling the admin API")
+	flag.Float64Var(&rate, "rate", 0,
+		"requests per second allowed per client (0 for no limit)")
+	flag.IntVar(&burst, "burst", 10,
+		"requests allowed per client in a burst when rate limiting")
+	flag.IntVar(&trustedHops, "trusted-hops", 0,
+		"number of proxies whose X-Forwarded-For entries are trusted")
+	flag.DurationVar(&healthEvery, "health-interval", 0,
+		"how often to check destinations, avoiding those down (0 to never)")
+	flag.DurationVar(&sweepEvery, "sweep-interval", time.Minute,
+		"how often to delete expired and used up links (0 to never)")
+	flag.StringVar(&historyFile, "history", "",
+		"a file recording every change made through the APIs, for rollback")
+}
+
+func main() {
+	flag.Parse()
+	if validateOnly {
+		os.Exit(validate(yamlFile, filepath.Ext(yamlFile), false))
+	}
+	switch cmd := flag.Arg(0); cmd {
+	case "check":
+		os.Exit(check(flag.Args()[1:]))
+	case "add", "rm", "ls", "mv", "validate":
+		os.Exit(links(cmd, flag.Args()[1:]))
+	}
+
+	log.SetPrefix("surl: ")
+
+	// The server's own endpoints, served ahead of the links so that no
+	// link can take one over
+	mux := http.NewServeMux()
+
+	// Open the sources of entries, watching their files for changes
+	// (and SIGHUP) to reload them
+	sources, store, files, err := openSources()
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+	for _, f := range files {
+		defer f.Watch(2 * time.Second)()
+	}
+
+	// Links created through the APIs must not be hidden by entries of the
+	// sources served before the store
+	store = urlshort.GuardShadowed(store, sources[:len(sources)-1])
+	sources[len(sources)-1].Store = store
+
+	// Recording the history of changes to the store, if asked to
+	var history *urlshort.HistoryStore
+	if historyFile != "" {
+		history, err = urlshort.OpenHistoryStore(store, historyFile)
+		if err != nil {
+			log.Fatalf("-history: %v", err)
+		}
+		defer history.Close()
+		store = history
+		sources[len(sources)-1].Store = history
+	}
+
+	// Short links created through the API go into the store, never
+	// under the paths of the endpoints
+	mux.Handle("/api/links", urlshort.CreateHandlerWithOptions(store, "",
+		urlshort.Options{ReservedCodes: reservedCodes}))
+
+	// As do links managed through the admin API, if enabled
+	if adminTokens != "" {
+		tokens, err := urlshort.LoadTokens(adminTokens)
+		if err != nil {
+			log.Fatalf("-admin-tokens: %v", err)
+		}
+		mux.Handle("/admin/links", urlshort.AdminHandler(store, tokens))
+		if history != nil {
+			mux.Handle("/admin/history", urlshort.HistoryHandler(history, tokens))
+		}
+	}
+
+	// Record the entries matched and expose them
+	stats := urlshort.NewStats()
+	mux.Handle("/api/stats", stats.JSONHandler())
+	mux.Handle("/metrics", stats.MetricsHandler())
+
+	// Route requests to the entries of the sources, in order, using
+	// the fallback for paths matching none and avoiding destinations
+	// found to be down where possible
+	var fallbackHandler http.Handler
+	notFound := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallbackHandler.ServeHTTP(w, r)
+	})
+	destHealth := urlshort.NewHealth()
+	onMatch := func(r *http.Request, e urlshort.Entry, dest string) {
+		stats.OnMatch(r, e, dest)
+		logMatch(r, e, dest)
+	}
+	router := urlshort.NewRouterWithOptions(sources, notFound,
+		urlshort.Options{Health: destHealth, OnMatch: onMatch})
+	for _, s := range router.Shadowed() {
+		log.Printf("%s from %s (%s) is shadowed by %s (%s)",
+			s.Key, s.Shadowed, s.Hidden, s.Source, s.URL)
+	}
+	if healthEvery > 0 {
+		defer destHealth.Watch(router.Store(), healthEvery, urlshort.CheckOptions{})()
+	}
+
+	// Deleting expired and used up links from whichever source has them
+	if sweepEvery > 0 {
+		defer urlshort.StartSweeper(router.Store(), sweepEvery)()
+	}
+
+	// Paths matching nothing get the fallback
+	fallbackHandler, err = newFallback(fallback, router.Store())
+	if err != nil {
+		log.Fatalf("-fallback: %v", err)
+	}
+
+	// QR codes for the short links
+	mux.Handle("/qr/", http.StripPrefix("/qr", urlshort.QRHandler(router.Store(), "")))
+
+	// Limit the rate of requests per client
+	limiter := urlshort.NewRateLimiter(urlshort.RateLimit{Rate: rate, Burst: burst})
+	limiter.TrustedHops = trustedHops
+
+	// Serve health and readiness ahead of everything else, ready
+	// once the files have loaded; a bad reload keeps serving the
+	// entries loaded before, so it does not make the server unready
+	readiness := &httpserve.Health{}
+	for _, f := range files {
+		readiness.AddCheck("link table", f.Loaded)
+	}
+	top := http.NewServeMux()
+	readiness.Register(top)
+	for _, code := range endpointCodes {
+		top.Handle("/"+code+"/", limiter.Handler(mux))
+	}
+	top.Handle("/metrics", limiter.Handler(mux))
+	top.Handle("/", limiter.Handler(router))
+
+	// Logging every request, if asked to
+	var handler http.Handler = top
+	if accessLog != "" {
+		l, err := httpserve.OpenAccessLog(accessLog, logFormat,
+			logMaxSize<<20, logKeep)
+		if err != nil {
+			log.Fatalf("-access-log: %v", err)
+		}
+		defer l.Close()
+		handler = l.Handler(top)
+	}
+
+	// Serving HTTPS, if asked to, redirecting plain HTTP requests to it
+	var serve func() error
+	scheme := "http"
+	certFile, keyFile, err := tlsFiles()
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := &http.Server{Addr: addr}
+	if certFile != "" {
+		if hsts > 0 {
+			handler = httpserve.HSTS(handler, hsts, hstsSubdomains)
+		}
+		serve = func() error {
+			return srv.ListenAndServeTLS(certFile, keyFile)
+		}
+		scheme = "https"
+
+		if httpAddr != "" {
+			redirect, err := httpserve.RedirectToHTTPS(addr)
+			if err != nil {
+				log.Fatalf("-addr: %v", err)
+			}
+			plain := &http.Server{Addr: httpAddr, Handler: redirect}
+			go func() {
+				err := plain.ListenAndServe()
+				if err != http.ErrServerClosed {
+					log.Fatalf("-http-addr: %v", err)
+				}
+			}()
+			defer plain.Close()
+			fmt.Println("Redirecting HTTP to HTTPS on", httpAddr)
+		}
+	}
+	srv.Handler = handler
+
+	readiness.SetReady(true)
+	fmt.Printf("Starting the server on %s (%s)\n", addr, scheme)
+	if err := httpserve.Run(srv, serve, readiness, drain); err != nil {
+		log.Fatal(err)
 	}
-	fmt.Println("Starting the server on :8080")
-	http.ListenAndServe(":8080", yamlHandler)
 }
 
-func defaultMux() *http.ServeMux {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", hello)
-	return mux
+// tlsFiles returns the certificate and key files to serve HTTPS with, given
+// by the -tls-cert and -tls-key flags or generated for -tls-self-signed
+// into the user's cache directory, or empty names to serve plain HTTP
+func tlsFiles() (string, string, error) {
+	switch {
+	case (tlsCert == "") != (tlsKey == ""):
+		return "", "", fmt.Errorf("-tls-cert and -tls-key must be given together")
+	case tlsCert != "" && selfSigned:
+		// Never overwrite the operator's own certificate
+		return "", "", fmt.Errorf("-tls-self-signed cannot be used with " +
+			"-tls-cert and -tls-key")
+	case tlsCert == "" && !selfSigned:
+		if httpAddr != "" || hsts > 0 {
+			return "", "", fmt.Errorf("-http-addr and -hsts need HTTPS " +
+				"(-tls-cert and -tls-key, or -tls-self-signed)")
+		}
+		return "", "", nil
+	case !selfSigned:
+		return tlsCert, tlsKey, nil
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", "", fmt.Errorf("-tls-self-signed: %v", err)
+	}
+	dir = filepath.Join(dir, "surl")
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", "", fmt.Errorf("-tls-self-signed: %v", err)
+	}
+	certFile := filepath.Join(dir, "self-signed-cert.pem")
+	keyFile := filepath.Join(dir, "self-signed-key.pem")
+
+	var hosts []string
+	for _, h := range strings.Split(tlsHosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	if err = httpserve.SelfSignedCert(certFile, keyFile, hosts); err != nil {
+		return "", "", fmt.Errorf("-tls-self-signed: %v", err)
+	}
+	return certFile, keyFile, nil
+}
+
+// openSources returns the sources of entries given by the flags, in order
+// of priority: the -yaml file, the -json file and then the store that links
+// created through the APIs go into, which is also returned. That is the
+// -store file if given, else kept in memory. Without any of those files,
+// the entries of the map and inline yaml are served. The handlers of the
+// files are returned too, for watching.
+func openSources() ([]urlshort.Source, urlshort.Store, []*urlshort.FileHandler, error) {
+	var sources []urlshort.Source
+	var files []*urlshort.FileHandler
+
+	for _, f := range []struct {
+		flag, fileName string
+		parse          func([]byte) ([]urlshort.Entry, error)
+	}{
+		{"yaml", yamlFile, urlshort.ParserFor(yamlFile)},
+		{"json", jsonFile, urlshort.ParseJSON},
+	} {
+		if f.fileName == "" {
+			continue
+		}
+		file, err := urlshort.NewFileHandlerWithParser(f.fileName, f.parse,
+			http.NotFoundHandler(), urlshort.Options{RefuseInvalid: true})
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("-%s: %v", f.flag, err)
+		}
+		sources = append(sources, urlshort.Source{Name: f.flag, Store: file.Store()})
+		files = append(files, file)
+	}
+
+	var store urlshort.Store
+	switch {
+	case storeFile != "":
+		fileStore, err := urlshort.NewJSONFileStore(storeFile)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("-store: %v", err)
+		}
+		store = fileStore
+		sources = append(sources, urlshort.Source{Name: "store", Store: store})
+
+	case len(sources) == 0:
+		inline, err := urlshort.ParsedSource("yaml", urlshort.ParseYAML,
+			[]byte(inlineYAML))
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("inline yaml: %v", err)
+		}
+		store = urlshort.NewMemoryStore(pathsToUrls)
+		sources = append(sources, inline, urlshort.Source{Name: "map", Store: store})
+
+	default:
+		store = urlshort.NewMemoryStore(nil)
+		sources = append(sources, urlshort.Source{Name: "store", Store: store})
+	}
+
+	return sources, store, files, nil
+}
+
+// newFallback returns the handler for paths matching no entry, given by
+// the -fallback flag: hello for the hello page, 404 for the not found page
+// suggesting paths of the store (with the hello page at the root only), or
+// an http or https URL to redirect to
+func newFallback(kind string, store urlshort.Store) (http.Handler, error) {
+	switch kind {
+	case "hello":
+		return http.HandlerFunc(hello), nil
+	case "404":
+		return defaultHandler(urlshort.NotFoundHandler(store)), nil
+	}
+
+	u, err := url.Parse(kind)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("'%s' is not hello, 404 or an http(s) URL", kind)
+	}
+	return http.RedirectHandler(kind, http.StatusFound), nil
+}
+
+// logMatch adds the short path a request matched and its destination to
+// its access log line, if requests are logged
+func logMatch(r *http.Request, e urlshort.Entry, dest string) {
+	httpserve.SetLogField(r, "short_path", e.Key())
+	httpserve.SetLogField(r, "destination", dest)
+}
+
+// validate prints any problems with the entries of the given file, read in
+// the format of the given extension (see urlshort.ParserFor), as text or
+// json, and returns the exit status: 0 if there are none, 1 if there are
+// some and 2 if the file cannot be read or parsed
+func validate(fileName, ext string, asJSON bool) int {
+	if fileName == "" {
+		fmt.Fprintln(os.Stderr, "-validate needs a file given with -yaml")
+		return 2
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var problems []urlshort.Problem
+	switch strings.ToLower(ext) {
+	case ".yaml", ".yml":
+		problems, err = urlshort.ValidateYAML(data)
+	default:
+		var entries []urlshort.Entry
+		entries, err = urlshort.ParserFor(ext)(data)
+		problems = urlshort.Validate(entries)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fileName, err)
+		return 2
+	}
+
+	if asJSON {
+		if problems == nil {
+			problems = []urlshort.Problem{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(problems)
+	} else {
+		for _, p := range problems {
+			if p.Line > 0 {
+				fmt.Printf("%s:%d: %s: %s\n", fileName, p.Line, p.Kind, p.Message)
+			} else {
+				fmt.Printf("%s: %s: %s: %s\n", fileName, p.Path, p.Kind, p.Message)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	if !asJSON {
+		fmt.Printf("%s: ok\n", fileName)
+	}
+	return 0
+}
+
+// check requests the destinations of the entries of the sources given by
+// the flags (see openSources) and prints a report of those that
+// are broken, slow or redirected. It returns the exit status: 0 if none are
+// broken, 1 if some are and 2 on a usage or parse error
+func check(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the report as json")
+	concurrency := fs.Int("concurrency", 8, "destinations checked at once")
+	timeout := fs.Duration("timeout", 10*time.Second,
+		"time allowed per destination")
+	slow := fs.Duration("slow", 2*time.Second,
+		"latency above which a destination is reported as slow")
+	redirects := fs.Int("max-redirects", 5, "redirects followed")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	sources, _, _, err := openSources()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	report := urlshort.Check(context.Background(),
+		urlshort.NewRouter(sources, nil).Store(), urlshort.CheckOptions{
+			Concurrency:  *concurrency,
+			Timeout:      *timeout,
+			MaxRedirects: *redirects,
+			Slow:         *slow,
+		})
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		report.WriteText(os.Stdout)
+	}
+	if report.Broken > 0 {
+		return 1
+	}
+	return 0
+}
+
+// defaultHandler says hello at the root and serves the given not found
+// handler for any other path
+func defaultHandler(notFound http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			hello(w, r)
+			return
+		}
+		notFound.ServeHTTP(w, r)
+	}
 }
 
 func hello(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,357 @@
+package urlshort
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scopes of access granted by an admin API token
+const (
+	ScopeRead  = "read"  // List and get entries
+	ScopeWrite = "write" // As well as create, update and delete them
+)
+
+// Token is what an admin API bearer token grants.
+type Token struct {
+	Scope  string // ScopeRead or ScopeWrite
+	Author string // Who changes made with the token are recorded as made by
+}
+
+// Tokens maps admin API bearer tokens to what they grant.
+type Tokens map[string]Token
+
+// LoadTokens reads admin API tokens from the given file, which has one
+// token per line followed by its scope and, optionally, the author changes
+// made with it are recorded as made by:
+//
+//	# Comment
+//	s3cr3t-token write alice
+//	another-token read
+//
+// Tokens without an author are recorded by a fingerprint of the token,
+// such as token-1a2b3c4d.
+func LoadTokens(fileName string) (Tokens, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tokens := make(Tokens)
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 ||
+			(fields[1] != ScopeRead && fields[1] != ScopeWrite) {
+			return nil, fmt.Errorf("%s line %d: expected 'token read|write [author]'",
+				fileName, lineNum)
+		}
+		token := Token{Scope: fields[1], Author: tokenFingerprint(fields[0])}
+		if len(fields) == 3 {
+			token.Author = fields[2]
+		}
+		tokens[fields[0]] = token
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// tokenOf returns what the bearer token of the request grants, or a zero
+// Token if it has no valid token. Every token is compared in constant time
+// so that timing does not give tokens away.
+func (t Tokens) tokenOf(r *http.Request) Token {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return Token{}
+	}
+	given := []byte(strings.TrimSpace(auth[len("Bearer "):]))
+
+	var found Token
+	for token, granted := range t {
+		if subtle.ConstantTimeCompare(given, []byte(token)) == 1 {
+			found = granted
+		}
+	}
+	return found
+}
+
+// tokenFingerprint returns a name for the token that does not give it away
+func tokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "token-" + hex.EncodeToString(sum[:4])
+}
+
+// AdminHandler returns an http.Handler for managing the entries of the
+// given store as JSON, protected by the given tokens. The entry acted on is
+// picked by the path and (optional) host query parameters:
+//
+//	GET    /?path=/x[&host=h]  Get an entry (all entries if no path)
+//	POST   /                   Create the entry in the body
+//	PUT    /?path=/x[&host=h]  Replace an entry with the one in the body
+//	DELETE /?path=/x[&host=h]  Delete an entry
+//
+// It ignores the request path, so mount it on a path of its own, such as
+// /admin/links. Created and updated entries are checked with Validate
+// (against the store's other entries and the given hosts the store is
+// served on) and refused with 422 and the problems found if they fail. If
+// the store is a HistoryStore, changes are recorded as made by the author
+// of the request's token.
+func AdminHandler(store Store, tokens Tokens, ownHosts ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := tokens.tokenOf(r)
+		if token.Scope == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="urlshort"`)
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		if r.Method != http.MethodGet && token.Scope != ScopeWrite {
+			writeJSONError(w, http.StatusForbidden, "token is read only")
+			return
+		}
+
+		key := ""
+		if path := r.URL.Query().Get("path"); path != "" {
+			key = EntryKey(r.URL.Query().Get("host"), path)
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			if key == "" {
+				writeJSON(w, http.StatusOK, store.List())
+				return
+			}
+			e, ok := store.Lookup(key)
+			if !ok {
+				writeJSONError(w, http.StatusNotFound, "no entry "+key)
+				return
+			}
+			writeJSON(w, http.StatusOK, e)
+
+		case http.MethodPost:
+			e, ok := decodeEntry(w, r)
+			if !ok {
+				return
+			}
+			if _, exists := store.Lookup(e.Key()); exists {
+				writeJSONError(w, http.StatusConflict, "entry "+e.Key()+" already exists")
+				return
+			}
+			if e.Created == nil {
+				now := time.Now()
+				e.Created = &now
+			}
+			putValidEntry(w, store, token.Author, e, "", http.StatusCreated, ownHosts)
+
+		case http.MethodPut:
+			if key == "" {
+				writeJSONError(w, http.StatusBadRequest, "path parameter is required")
+				return
+			}
+			if _, exists := store.Lookup(key); !exists {
+				writeJSONError(w, http.StatusNotFound, "no entry "+key)
+				return
+			}
+			e, ok := decodeEntry(w, r)
+			if !ok {
+				return
+			}
+			if _, exists := store.Lookup(e.Key()); exists && e.Key() != key {
+				writeJSONError(w, http.StatusConflict, "entry "+e.Key()+" already exists")
+				return
+			}
+			putValidEntry(w, store, token.Author, e, key, http.StatusOK, ownHosts)
+
+		case http.MethodDelete:
+			if key == "" {
+				writeJSONError(w, http.StatusBadRequest, "path parameter is required")
+				return
+			}
+			if _, exists := store.Lookup(key); !exists {
+				writeJSONError(w, http.StatusNotFound, "no entry "+key)
+				return
+			}
+			if err := storeChange(store, token.Author, nil, []string{key}); err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.Header().Set("Allow", "GET, POST, PUT, DELETE")
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
+
+// HistoryHandler returns an http.Handler for the history of the entries of
+// the given store as JSON, protected by the given tokens (rolling back
+// needs a write token). Like AdminHandler it ignores the request path and
+// picks the entry acted on, if any, by the path and host query parameters:
+//
+//	GET  /[?path=/x[&host=h]]                 List revisions
+//	GET  /?from=1&to=3[&path=/x[&host=h]]     Changes between revisions
+//	POST /?to=3[&path=/x[&host=h]]            Roll back to a revision
+//
+// Revision 0 is the empty table before the first revision. Roll backs are
+// recorded as made by the author of the request's token.
+func HistoryHandler(history *HistoryStore, tokens Tokens) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := tokens.tokenOf(r)
+		if token.Scope == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="urlshort"`)
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		if r.Method != http.MethodGet && token.Scope != ScopeWrite {
+			writeJSONError(w, http.StatusForbidden, "token is read only")
+			return
+		}
+
+		query := r.URL.Query()
+		key := ""
+		if path := query.Get("path"); path != "" {
+			key = EntryKey(query.Get("host"), path)
+		}
+		revision := func(name string) (int, bool) {
+			id, err := strconv.Atoi(query.Get(name))
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest,
+					name+" parameter must be a revision number")
+				return 0, false
+			}
+			return id, true
+		}
+
+		switch {
+		case r.Method == http.MethodGet && query.Get("to") == "":
+			revisions := history.Revisions(key)
+			if revisions == nil {
+				revisions = []Revision{}
+			}
+			writeJSON(w, http.StatusOK, revisions)
+
+		case r.Method == http.MethodGet:
+			from, ok := revision("from")
+			if !ok {
+				return
+			}
+			to, ok := revision("to")
+			if !ok {
+				return
+			}
+			changes, err := history.Diff(from, to, key)
+			if err != nil {
+				writeJSONError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			if changes == nil {
+				changes = []Change{}
+			}
+			writeJSON(w, http.StatusOK, changes)
+
+		case r.Method == http.MethodPost:
+			to, ok := revision("to")
+			if !ok {
+				return
+			}
+			rev, err := history.Rollback(token.Author, to, key)
+			switch {
+			case err == errNoRevision:
+				writeJSONError(w, http.StatusNotFound, err.Error())
+			case err != nil:
+				writeJSONError(w, http.StatusInternalServerError, err.Error())
+			case rev == nil:
+				w.WriteHeader(http.StatusNoContent) // Nothing to roll back
+			default:
+				writeJSON(w, http.StatusOK, rev)
+			}
+
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
+
+// writeStoreError writes the response for an error changing the store: 409
+// for entries hidden by an earlier source (see GuardShadowed), else 500
+func writeStoreError(w http.ResponseWriter, err error) {
+	if err == ErrShadowed {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSONError(w, http.StatusInternalServerError, err.Error())
+}
+
+// decodeEntry decodes the entry in the request body, writing an error
+// response and returning false if it cannot
+func decodeEntry(w http.ResponseWriter, r *http.Request) (Entry, bool) {
+	var e Entry
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&e); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid entry: "+err.Error())
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// putValidEntry validates the entry against the rest of the store and puts
+// it as the given author, replacing the entry with the given old key (if
+// any), then responds with the entry and the given status. If validation
+// fails it responds 422 with the problems found instead.
+func putValidEntry(w http.ResponseWriter, store Store, author string,
+	e Entry, oldKey string, status int, ownHosts []string) {
+
+	// Validate the entry in the table as it would be once stored
+	var table []Entry
+	for _, other := range store.List() {
+		if other.Key() != oldKey && other.Key() != e.Key() {
+			table = append(table, other)
+		}
+	}
+	table = append(table, e)
+
+	var problems []Problem
+	for _, p := range Validate(table, ownHosts...) {
+		if p.Entry == len(table)-1 {
+			p.Entry = 0 // Only the one entry is of interest to the client
+			problems = append(problems, p)
+		}
+	}
+	if len(problems) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":    "entry failed validation",
+			"problems": problems,
+		})
+		return
+	}
+
+	var deletes []string
+	if oldKey != "" && oldKey != e.Key() {
+		deletes = []string{oldKey}
+	}
+	if err := storeChange(store, author, []Entry{e}, deletes); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	stored, _ := store.Lookup(e.Key())
+	writeJSON(w, status, stored)
+}
@@ -0,0 +1,179 @@
+package urlshort
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	history := NewHistoryStore(NewMemoryStoreFromEntries([]Entry{
+		{Path: "/a", URL: "https://example.com/a"},
+	}))
+	tokens := Tokens{
+		"reader-token": {Scope: ScopeRead, Author: "bob"},
+		"writer-token": {Scope: ScopeWrite, Author: "alice"},
+	}
+	handler := AdminHandler(history, tokens, "go.example.com")
+
+	// The steps share the store, so later ones see the changes of earlier ones
+	tests := []struct {
+		name     string
+		token    string
+		method   string
+		query    string
+		body     string
+		status   int
+		wantBody string // Substring of the response
+	}{
+		{"no token", "", http.MethodGet, "", "", http.StatusUnauthorized, "missing or invalid token"},
+		{"unknown token", "guess", http.MethodGet, "", "", http.StatusUnauthorized, ""},
+		{"list", "reader-token", http.MethodGet, "", "", http.StatusOK, `"path": "/a"`},
+		{"get", "reader-token", http.MethodGet, "?path=/a", "", http.StatusOK,
+			`"url": "https://example.com/a"`},
+		{"get missing", "reader-token", http.MethodGet, "?path=/zzz", "", http.StatusNotFound,
+			"no entry /zzz"},
+		{"create read only", "reader-token", http.MethodPost, "",
+			`{"path": "/b", "url": "https://example.com/b"}`, http.StatusForbidden,
+			"token is read only"},
+		{"delete read only", "reader-token", http.MethodDelete, "?path=/a", "",
+			http.StatusForbidden, "token is read only"},
+		{"create", "writer-token", http.MethodPost, "",
+			`{"path": "/b", "url": "https://example.com/b"}`, http.StatusCreated, `"path": "/b"`},
+		{"create conflict", "writer-token", http.MethodPost, "",
+			`{"path": "/a", "url": "https://example.com/other"}`, http.StatusConflict,
+			"entry /a already exists"},
+		{"rename conflict", "writer-token", http.MethodPut, "?path=/b",
+			`{"path": "/a", "url": "https://example.com/b"}`, http.StatusConflict,
+			"entry /a already exists"},
+		{"rename", "writer-token", http.MethodPut, "?path=/b",
+			`{"path": "/c", "url": "https://example.com/c"}`, http.StatusOK, `"path": "/c"`},
+		{"renamed away", "reader-token", http.MethodGet, "?path=/b", "", http.StatusNotFound, ""},
+		{"update missing", "writer-token", http.MethodPut, "?path=/zzz",
+			`{"path": "/zzz", "url": "https://example.com"}`, http.StatusNotFound, ""},
+		{"update without path", "writer-token", http.MethodPut, "",
+			`{"path": "/a", "url": "https://example.com"}`, http.StatusBadRequest,
+			"path parameter is required"},
+		{"bad scheme", "writer-token", http.MethodPost, "",
+			`{"path": "/d", "url": "ftp://example.com/file"}`, http.StatusUnprocessableEntity,
+			`"kind": "scheme"`},
+		{"chain", "writer-token", http.MethodPost, "",
+			`{"path": "/d", "url": "https://go.example.com/a"}`,
+			http.StatusUnprocessableEntity, "redirect chain /d -\\u003e /a"},
+		{"invalid update", "writer-token", http.MethodPut, "?path=/c",
+			`{"path": "/c", "url": "https://example.com", "status": 200}`,
+			http.StatusUnprocessableEntity, `"kind": "bad-option"`},
+		{"unknown field", "writer-token", http.MethodPost, "",
+			`{"path": "/d", "uri": "https://example.com"}`, http.StatusBadRequest,
+			`unknown field \"uri\"`},
+		{"bad method", "writer-token", http.MethodPatch, "?path=/a", "",
+			http.StatusMethodNotAllowed, ""},
+		{"delete", "writer-token", http.MethodDelete, "?path=/c", "", http.StatusNoContent, ""},
+		{"delete missing", "writer-token", http.MethodDelete, "?path=/c", "",
+			http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/admin/links"+tt.query,
+				strings.NewReader(tt.body))
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body %q does not contain %q", rec.Body.String(), tt.wantBody)
+			}
+			if tt.status == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+		})
+	}
+
+	// Refused changes are not recorded, and the rest are by the token's author
+	revisions := history.Revisions("")
+	if len(revisions) != 4 { // The initial entries, create, rename and delete
+		t.Fatalf("got %d revisions, want 4", len(revisions))
+	}
+	for _, rev := range revisions[1:] {
+		if rev.Author != "alice" {
+			t.Errorf("revision %d by %q, want alice", rev.ID, rev.Author)
+		}
+	}
+}
+
+func TestAdminHandlerValidationResponse(t *testing.T) {
+	store := NewMemoryStoreFromEntries(nil)
+	handler := AdminHandler(store, Tokens{"t": {Scope: ScopeWrite, Author: "alice"}})
+
+	r := httptest.NewRequest(http.MethodPost, "/",
+		strings.NewReader(`{"path": "relative", "url": "example.com"}`))
+	r.Header.Set("Authorization", "Bearer t")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want 422: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Error    string    `json:"error"`
+		Problems []Problem `json:"problems"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := []Problem{
+		{Path: "relative", Kind: ProblemBadPath,
+			Message: "path 'relative' does not start with '/'"},
+		{Path: "relative", Kind: ProblemBadURL, Message: "url 'example.com' is not absolute"},
+	}
+	if body.Error != "entry failed validation" || len(body.Problems) != len(want) {
+		t.Fatalf("response %+v, want problems %+v", body, want)
+	}
+	for i := range want {
+		if body.Problems[i] != want[i] {
+			t.Errorf("problem %d = %+v, want %+v", i, body.Problems[i], want[i])
+		}
+	}
+	if len(store.List()) != 0 {
+		t.Error("invalid entry stored")
+	}
+}
+
+func TestLoadTokens(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "tokens")
+	err := ioutil.WriteFile(good, []byte("# Tokens\n\nw-token write alice\n  r-token read\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := LoadTokens(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tokens["w-token"]; got != (Token{Scope: ScopeWrite, Author: "alice"}) {
+		t.Errorf("w-token = %+v", got)
+	}
+	if got := tokens["r-token"]; got.Scope != ScopeRead ||
+		!strings.HasPrefix(got.Author, "token-") || strings.Contains(got.Author, "r-token") {
+		t.Errorf("r-token = %+v, want read with a fingerprint author", got)
+	}
+
+	for _, line := range []string{"token", "token admin", "token write alice extra"} {
+		bad := filepath.Join(dir, "bad")
+		if err := ioutil.WriteFile(bad, []byte(line+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadTokens(bad); err == nil || !strings.Contains(err.Error(), "line 1") {
+			t.Errorf("LoadTokens() of %q error = %v", line, err)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package urlshort
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Longest path derived from a bookmark title, not counting the leading '/'
+// or any suffix added to resolve a collision
+const maxSlugLength = 48
+
+// ImportOptions controls how bookmarks and CSV rows become entries.
+type ImportOptions struct {
+	// Derive a path from the title (or description) of each bookmark or
+	// row that has none, instead of reporting it as an error. Derived
+	// paths are given a -2, -3, ... suffix where needed to keep them unique.
+	DerivePaths bool
+
+	// Optional store of existing entries whose paths derived paths avoid
+	Existing Store
+}
+
+// Netscape bookmark file elements: links with their attributes and title,
+// each optionally followed by a description
+var (
+	bookmarkRegexp = regexp.MustCompile(
+		`(?is)<a\s([^>]*)>(.*?)</a>(?:\s*<dd>([^<]*))?`)
+	attrRegexp = regexp.MustCompile(
+		`([A-Za-z_:-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	tagRegexp = regexp.MustCompile(`<[^>]*>`)
+)
+
+// ParseBookmarks parses entries from a bookmark file in the Netscape format
+// exported by browsers:
+//
+//	<DT><A HREF="https://www.some-url.com/demo" SHORTCUTURL="/some-path">Demo</A>
+//	<DD>Demo of the site
+//
+// The short path of each bookmark is taken from its SHORTCUTURL (keyword)
+// attribute, which may be preceded by a host as in ParseFlat. The
+// description is taken from DD, else the title unless it only repeats the
+// path or URL. Folders are ignored.
+// Bookmarks without a path are reported as errors; see ImportBookmarks to
+// derive paths for them instead.
+func ParseBookmarks(data []byte) ([]Entry, error) {
+	return ImportBookmarks(data, ImportOptions{})
+}
+
+// ImportBookmarks is the same as ParseBookmarks but with the handling of
+// bookmarks without a path given by the options.
+func ImportBookmarks(data []byte, opts ImportOptions) ([]Entry, error) {
+	var entries []Entry
+	var titles []string
+
+	for _, m := range bookmarkRegexp.FindAllSubmatchIndex(data, -1) {
+		line := bytes.Count(data[:m[0]], []byte("\n")) + 1
+		attrs := bookmarkAttrs(string(data[m[2]:m[3]]))
+		title := strings.TrimSpace(html.UnescapeString(
+			tagRegexp.ReplaceAllString(string(data[m[4]:m[5]]), "")))
+
+		e := Entry{URL: attrs["href"], Description: title}
+		hasDD := m[6] >= 0
+		if hasDD {
+			// Whitespace of the description itself is escaped (see
+			// escapeBookmarkText), so only the layout's is trimmed
+			e.Description = html.UnescapeString(
+				strings.TrimSpace(string(data[m[6]:m[7]])))
+		}
+		if e.URL == "" {
+			return nil, fmt.Errorf("line %d: bookmark has no HREF", line)
+		}
+		if err := setBookmarkAttrs(&e, attrs); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if e.Path == "" && !opts.DerivePaths {
+			return nil, fmt.Errorf("line %d: bookmark %q has no SHORTCUTURL",
+				line, title)
+		}
+
+		// A title that just repeats the path or URL is no description
+		if !hasDD && (e.Description == e.Path || e.Description == e.Key() ||
+			e.Description == e.URL) {
+			e.Description = ""
+		}
+		if title == e.URL {
+			title = ""
+		}
+		entries = append(entries, e)
+		titles = append(titles, title)
+	}
+
+	derivePaths(entries, titles, opts.Existing)
+	return entries, nil
+}
+
+// bookmarkAttrs returns the attributes of a bookmark link, keyed by their
+// lower cased names
+func bookmarkAttrs(s string) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range attrRegexp.FindAllStringSubmatch(s, -1) {
+		attrs[strings.ToLower(m[1])] = html.UnescapeString(m[2] + m[3] + m[4])
+	}
+	return attrs
+}
+
+// setBookmarkAttrs sets the fields of the entry given by the bookmark
+// attributes other than HREF
+func setBookmarkAttrs(e *Entry, attrs map[string]string) error {
+	var err error
+	if path := attrs["shortcuturl"]; path != "" {
+		e.Host, e.Path = SplitKey(path)
+		if !strings.HasPrefix(e.Path, "/") {
+			e.Path = "/" + e.Path
+		}
+	}
+
+	parseTime := func(name string, unix bool) *time.Time {
+		s := attrs[name]
+		if s == "" || err != nil {
+			return nil
+		}
+		var t time.Time
+		if unix {
+			var secs int64
+			secs, err = strconv.ParseInt(s, 10, 64)
+			t = time.Unix(secs, 0).UTC()
+		} else {
+			t, err = time.Parse(time.RFC3339, s)
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid %s: %v", strings.ToUpper(name), err)
+			return nil
+		}
+		return &t
+	}
+	parseInt := func(name string) int {
+		s := attrs[name]
+		if s == "" || err != nil {
+			return 0
+		}
+		var n int
+		n, err = strconv.Atoi(s)
+		if err != nil {
+			err = fmt.Errorf("invalid %s: %v", strings.ToUpper(name), err)
+		}
+		return n
+	}
+
+	// CREATED, written by WriteBookmarks, has the precision ADD_DATE lacks
+	e.Created = parseTime("add_date", true)
+	if created := parseTime("created", false); created != nil {
+		e.Created = created
+	}
+	e.ExpiresAt = parseTime("expires_at", false)
+	e.MaxUses = parseInt("max_uses")
+	e.Uses = parseInt("uses")
+	e.Status = parseInt("status")
+	e.Query = attrs["query"]
+	e.Interstitial = attrs["interstitial"] != ""
+	e.Weight = parseInt("weight")
+	e.Sticky = attrs["sticky"] != ""
+	for _, name := range []string{"split", "fallbacks"} {
+		if s := attrs[name]; s != "" && err == nil {
+			if name == "split" {
+				err = json.Unmarshal([]byte(s), &e.Split)
+			} else {
+				err = json.Unmarshal([]byte(s), &e.Fallbacks)
+			}
+			if err != nil {
+				err = fmt.Errorf("invalid %s: %v", strings.ToUpper(name), err)
+			}
+		}
+	}
+	return err
+}
+
+// WriteBookmarks writes the entries as a Netscape format bookmark file,
+// which browsers can import. Each entry's path (preceded by its host, if
+// any) is written as the bookmark's SHORTCUTURL and its description as the
+// title and DD. Fields of an Entry with no bookmark equivalent (and the
+// creation time, which ADD_DATE only holds to the second) are written as
+// extra attributes, so reading the file back with ParseBookmarks gives the
+// same entries.
+func WriteBookmarks(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<!-- This is an automatically generated file. -->
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+`)
+	for _, e := range entries {
+		attr := func(name, value string) {
+			fmt.Fprintf(bw, ` %s="%s"`, name, html.EscapeString(value))
+		}
+
+		bw.WriteString(`    <DT><A`)
+		attr("HREF", e.URL)
+		if e.Created != nil {
+			attr("ADD_DATE", strconv.FormatInt(e.Created.Unix(), 10))
+			attr("CREATED", e.Created.Format(time.RFC3339Nano))
+		}
+		attr("SHORTCUTURL", e.Key())
+		if e.ExpiresAt != nil {
+			attr("EXPIRES_AT", e.ExpiresAt.Format(time.RFC3339Nano))
+		}
+		if e.MaxUses != 0 {
+			attr("MAX_USES", strconv.Itoa(e.MaxUses))
+		}
+		if e.Uses != 0 {
+			attr("USES", strconv.Itoa(e.Uses))
+		}
+		if e.Status != 0 {
+			attr("STATUS", strconv.Itoa(e.Status))
+		}
+		if e.Query != "" {
+			attr("QUERY", e.Query)
+		}
+		if e.Interstitial {
+			attr("INTERSTITIAL", "1")
+		}
+		if e.Weight != 0 {
+			attr("WEIGHT", strconv.Itoa(e.Weight))
+		}
+		if len(e.Split) > 0 {
+			split, _ := json.Marshal(e.Split)
+			attr("SPLIT", string(split))
+		}
+		if e.Sticky {
+			attr("STICKY", "1")
+		}
+		if len(e.Fallbacks) > 0 {
+			fallbacks, _ := json.Marshal(e.Fallbacks)
+			attr("FALLBACKS", string(fallbacks))
+		}
+
+		if e.Description == "" {
+			fmt.Fprintf(bw, ">%s</A>\n", html.EscapeString(e.Key()))
+			continue
+		}
+		fmt.Fprintf(bw, ">%s</A>\n", html.EscapeString(e.Description))
+		fmt.Fprintf(bw, "    <DD>%s\n", escapeBookmarkText(e.Description))
+	}
+	bw.WriteString("</DL><p>\n")
+	return bw.Flush()
+}
+
+// escapeBookmarkText escapes the given text for a bookmark file, including
+// any leading and trailing whitespace, which readers trim otherwise
+func escapeBookmarkText(s string) string {
+	escaped := html.EscapeString(s)
+	body := strings.TrimLeft(escaped, " \t\r\n")
+	lead := escaped[:len(escaped)-len(body)]
+	body = strings.TrimRight(body, " \t\r\n")
+	trail := escaped[len(lead)+len(body):]
+	return entityEscape(lead) + body + entityEscape(trail)
+}
+
+// entityEscape writes each character of s as a numeric character reference
+func entityEscape(s string) string {
+	var sb strings.Builder
+	for _, c := range s {
+		fmt.Fprintf(&sb, "&#%d;", c)
+	}
+	return sb.String()
+}
+
+// derivePaths sets the path of each entry without one to a slug of its
+// title (or description, or destination host), keeping it unique among the
+// entries and those in the existing store, if any
+func derivePaths(entries []Entry, titles []string, existing Store) {
+	taken := make(map[string]bool)
+	for _, e := range entries {
+		if e.Path != "" {
+			taken[e.Key()] = true
+		}
+	}
+	isTaken := func(key string) bool {
+		if taken[key] {
+			return true
+		}
+		if existing != nil {
+			_, ok := existing.Lookup(key)
+			return ok
+		}
+		return false
+	}
+
+	for i := range entries {
+		e := &entries[i]
+		if e.Path != "" {
+			continue
+		}
+
+		slug := slugify(titles[i])
+		if slug == "" {
+			slug = slugify(e.Description)
+		}
+		if slug == "" {
+			if u, err := url.Parse(e.URL); err == nil {
+				slug = slugify(strings.TrimPrefix(u.Hostname(), "www."))
+			}
+		}
+		if slug == "" {
+			slug = "link"
+		}
+
+		e.Path = "/" + slug
+		for n := 2; isTaken(e.Key()); n++ {
+			e.Path = "/" + slug + "-" + strconv.Itoa(n)
+		}
+		taken[e.Key()] = true
+	}
+}
+
+// slugify returns the letters and digits of the given string lower cased,
+// with each run of other characters turned into a single '-'
+func slugify(s string) string {
+	var sb strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(s) {
+		if ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') {
+			if dash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteRune(c)
+			dash = false
+		} else {
+			dash = true
+		}
+		if sb.Len() >= maxSlugLength {
+			break
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,179 @@
+package urlshort
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBookmarksRoundTrip(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.UTC)
+	expires := time.Date(2025, 1, 2, 3, 4, 5, 600000000, time.UTC)
+	entries := []Entry{
+		{Path: "/plain", URL: "https://example.com"},
+		{Path: "/described", URL: "https://example.com/a?b=1&c=2",
+			Description: "Docs <draft> & \"notes\"", Created: &created},
+		{Path: "/spaced", URL: "https://example.com/s", Description: "  padded\n"},
+		{Path: "/same", URL: "https://example.com/same", Description: "/same"},
+		{Path: "/url-title", URL: "https://example.com/u", Description: "https://example.com/u"},
+		{Host: "go.example.com", Path: "/hosted", URL: "https://example.com/h",
+			Description: "On a host"},
+		{Path: "/limited", URL: "https://example.com/l", ExpiresAt: &expires, MaxUses: 3,
+			Uses: 1, Status: 301, Query: "merge", Interstitial: true},
+		{Path: "/split", URL: "https://example.com/1", Weight: 2,
+			Split: []Target{{URL: "https://example.com/2", Weight: 1}}, Sticky: true,
+			Fallbacks: []string{"https://backup.example.com"}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteBookmarks(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseBookmarks(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseBookmarks() error: %v\n%s", err, buf.String())
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("round trip changed the entries\n got: %+v\nwant: %+v\nfile:\n%s",
+			got, entries, buf.String())
+	}
+}
+
+func TestImportBookmarks(t *testing.T) {
+	data := `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<DL><p>
+    <DT><H3>Folder</H3>
+    <DL><p>
+        <DT><A HREF="https://example.com/a" ADD_DATE="1700000000" SHORTCUTURL="a">/a</A>
+        <DT><A HREF="https://example.com/b" SHORTCUTURL="go.example.com/b">Bee</A>
+        <DD>The bee page
+        <DT><A HREF="https://example.com/c">Site Title</A>
+        <DT><A HREF="https://example.com/d">https://example.com/d</A>
+    </DL><p>
+</DL><p>
+`
+	if _, err := ParseBookmarks([]byte(data)); err == nil ||
+		!strings.Contains(err.Error(), "line 8") {
+		t.Errorf("ParseBookmarks() error = %v, want one for line 8", err)
+	}
+
+	got, err := ImportBookmarks([]byte(data), ImportOptions{DerivePaths: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := time.Unix(1700000000, 0).UTC()
+	want := []Entry{
+		{Path: "/a", URL: "https://example.com/a", Created: &added},
+		{Host: "go.example.com", Path: "/b", URL: "https://example.com/b",
+			Description: "The bee page"},
+		{Path: "/site-title", URL: "https://example.com/c", Description: "Site Title"},
+		{Path: "/example-com", URL: "https://example.com/d"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ImportBookmarks() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	entries := []Entry{
+		{Path: "/plain", URL: "https://example.com"},
+		{Path: "/described", URL: "https://example.com/a?b=1,2",
+			Description: `Has "quotes", commas`},
+		{Host: "go.example.com", Path: "/hosted", URL: "https://example.com/h"},
+	}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "path,url,description\n") {
+		t.Errorf("WriteCSV() output has no header:\n%s", buf.String())
+	}
+	got, err := ParseCSV(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("round trip changed the entries\n got: %+v\nwant: %+v", got, entries)
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		opts    ImportOptions
+		want    []Entry
+		wantErr string
+	}{
+		{"no header", "/a,https://example.com/a\n", ImportOptions{},
+			[]Entry{{Path: "/a", URL: "https://example.com/a"}}, ""},
+		{"comments and spaces", "# links\nPath,URL\n/a, https://example.com/a , A \n",
+			ImportOptions{},
+			[]Entry{{Path: "/a", URL: "https://example.com/a", Description: "A"}}, ""},
+		{"host", "go.example.com/a,https://example.com/a\n", ImportOptions{},
+			[]Entry{{Host: "go.example.com", Path: "/a", URL: "https://example.com/a"}}, ""},
+		{"missing path", "/a,https://example.com/a\n,https://example.com/b\n",
+			ImportOptions{}, nil, "line 2: row has no path"},
+		{"missing url", "/a,\n", ImportOptions{}, nil, "line 1: row has no url"},
+		{"too many fields", "/a,https://example.com/a,A,extra\n", ImportOptions{}, nil,
+			"line 1: expected path,url[,description]"},
+		{"derived path", ",https://example.com/b,Bee Page\n",
+			ImportOptions{DerivePaths: true},
+			[]Entry{{Path: "/bee-page", URL: "https://example.com/b",
+				Description: "Bee Page"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ImportCSV([]byte(tt.data), tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("ImportCSV() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ImportCSV() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDerivePathsCollisions(t *testing.T) {
+	existing := NewMemoryStoreFromEntries([]Entry{
+		{Path: "/docs", URL: "https://example.com"},
+		{Path: "/docs-2", URL: "https://example.com"},
+	})
+	entries := []Entry{
+		{URL: "https://example.com/1"},
+		{Path: "/docs-3", URL: "https://example.com/given"},
+		{URL: "https://example.com/2"},
+		{URL: "https://example.com/3"},
+		{Host: "go.example.com", URL: "https://example.com/4"},
+		{URL: "https://www.example.org/5"},
+		{URL: "https://www.example.org/6"},
+		{URL: "mailto:someone"},
+	}
+	titles := []string{"Docs", "", "docs!", "DOCS", "Docs", "", "", "!!"}
+	derivePaths(entries, titles, existing)
+
+	want := []string{
+		"/docs-4", // docs, -2 are in the store and -3 in the entries
+		"/docs-3", // given paths are kept
+		"/docs-5", // titles slugify the same
+		"/docs-6",
+		"go.example.com/docs", // other hosts do not collide
+		"/example-org",        // from the host without www.
+		"/example-org-2",
+		"/link", // nothing to slugify
+	}
+	for i, e := range entries {
+		if e.Key() != want[i] {
+			t.Errorf("entry %d key = %q, want %q", i, e.Key(), want[i])
+		}
+	}
+}
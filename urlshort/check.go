@@ -0,0 +1,241 @@
+package urlshort
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Defaults for CheckOptions fields left unset
+const (
+	defaultCheckConcurrency = 8
+	defaultCheckTimeout     = 10 * time.Second
+	defaultCheckRedirects   = 5
+	defaultCheckSlow        = 2 * time.Second
+)
+
+// CheckOptions controls how Check requests the destinations of entries.
+type CheckOptions struct {
+	Concurrency  int           // Requests in flight at once (default 8)
+	Timeout      time.Duration // Per destination, for all requests (default 10s)
+	MaxRedirects int           // Redirects followed (default 5)
+	Slow         time.Duration // Latency counted as slow (default 2s)
+
+	// Client used for requests, whose CheckRedirect and Timeout are
+	// overridden (default http.DefaultClient's transport)
+	Client *http.Client
+}
+
+// CheckResult is the outcome of checking the destination of one entry.
+type CheckResult struct {
+	Path       string        `json:"path"`
+	Host       string        `json:"host,omitempty"`
+	URL        string        `json:"url"`
+	Method     string        `json:"method,omitempty"` // That gave the status
+	Status     int           `json:"status,omitempty"`
+	FinalURL   string        `json:"final_url,omitempty"` // After redirects
+	Redirects  int           `json:"redirects,omitempty"`
+	Latency    time.Duration `json:"latency_ns"`
+	Error      string        `json:"error,omitempty"`
+	Broken     bool          `json:"broken"`
+	Slow       bool          `json:"slow"`
+	Redirected bool          `json:"redirected"`
+	Skipped    bool          `json:"skipped,omitempty"` // Pattern with parameters
+}
+
+// CheckReport holds the results of Check, sorted by path. An entry with
+// several destinations has a result for each.
+type CheckReport struct {
+	Checked    int           `json:"checked"`
+	Broken     int           `json:"broken"`
+	Slow       int           `json:"slow"`
+	Redirected int           `json:"redirected"`
+	Results    []CheckResult `json:"results"`
+}
+
+// Check requests the destinations of every entry in the store (including
+// those it splits requests to and falls back on), with HEAD and then GET if
+// HEAD fails, and reports those that are broken (failing or responding with
+// an error status), slow or redirected elsewhere. Destinations with pattern
+// parameters are skipped.
+func Check(ctx context.Context, store Store, opts CheckOptions) *CheckReport {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultCheckConcurrency
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultCheckTimeout
+	}
+	if opts.MaxRedirects <= 0 {
+		opts.MaxRedirects = defaultCheckRedirects
+	}
+	if opts.Slow <= 0 {
+		opts.Slow = defaultCheckSlow
+	}
+
+	// Each entry's own URL is checked, along with those it splits requests
+	// to and falls back on
+	var entries []Entry
+	for _, e := range store.List() {
+		for _, url := range e.allURLs() {
+			e.URL = url
+			entries = append(entries, e)
+		}
+	}
+	results := make([]CheckResult, len(entries))
+
+	// Hand out the entries to a bounded number of workers
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = checkEntry(ctx, entries[i], opts)
+			}
+		}()
+	}
+	for i := range entries {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	report := &CheckReport{Results: results}
+	for _, r := range results {
+		if r.Skipped {
+			continue
+		}
+		report.Checked++
+		if r.Broken {
+			report.Broken++
+		}
+		if r.Slow {
+			report.Slow++
+		}
+		if r.Redirected {
+			report.Redirected++
+		}
+	}
+	return report
+}
+
+// checkEntry checks the destination of a single entry
+func checkEntry(ctx context.Context, e Entry, opts CheckOptions) CheckResult {
+	result := CheckResult{Path: e.Path, Host: e.Host, URL: e.URL}
+	if strings.Contains(e.URL, "{") && isPattern(e.Path) {
+		result.Skipped = true
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		result.Method = method
+		result.Status, result.FinalURL, result.Redirects, result.Error =
+			checkRequest(ctx, method, e.URL, opts)
+		if result.Error == "" && result.Status < 400 {
+			break
+		}
+		if ctx.Err() != nil {
+			break // No time left for GET
+		}
+	}
+	result.Latency = time.Since(start)
+
+	result.Broken = result.Error != "" || result.Status >= 400
+	result.Slow = result.Latency > opts.Slow
+	result.Redirected = result.Redirects > 0
+	return result
+}
+
+var errTooManyRedirects = errors.New("too many redirects")
+
+// checkRequest makes a single request (following redirects), returning the
+// final status and URL, the number of redirects and any error
+func checkRequest(ctx context.Context, method, url string,
+	opts CheckOptions) (int, string, int, string) {
+
+	redirects := 0
+	client := http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > opts.MaxRedirects {
+				return errTooManyRedirects
+			}
+			redirects = len(via)
+			return nil
+		},
+	}
+	if opts.Client != nil {
+		client.Transport = opts.Client.Transport
+		client.Jar = opts.Client.Jar
+	}
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return 0, "", 0, err.Error()
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "urlshort-checker/1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", redirects, err.Error()
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	return resp.StatusCode, resp.Request.URL.String(), redirects, ""
+}
+
+// WriteText writes the report as a table of the destinations that are
+// broken, slow or redirected, followed by a summary line.
+func (r *CheckReport) WriteText(w io.Writer) error {
+	results := append([]CheckResult(nil), r.Results...)
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Broken && !results[j].Broken
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROBLEM\tPATH\tSTATUS\tLATENCY\tURL\tDETAIL")
+	for _, res := range results {
+		var problems []string
+		if res.Broken {
+			problems = append(problems, "broken")
+		}
+		if res.Slow {
+			problems = append(problems, "slow")
+		}
+		if res.Redirected {
+			problems = append(problems, "redirected")
+		}
+		if len(problems) == 0 {
+			continue
+		}
+
+		detail := res.Error
+		if detail == "" && res.Redirected {
+			detail = "-> " + res.FinalURL
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", strings.Join(problems, ","),
+			EntryKey(res.Host, res.Path), res.Status,
+			res.Latency.Round(time.Millisecond), res.URL, detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "%d checked: %d broken, %d slow, %d redirected\n",
+		r.Checked, r.Broken, r.Slow, r.Redirected)
+	return err
+}
@@ -0,0 +1,89 @@
+package urlshort
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/no-head", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var entries []Entry
+	for _, path := range []string{"/ok", "/broken", "/slow", "/moved", "/loop", "/no-head"} {
+		entries = append(entries, Entry{Path: path, URL: server.URL + path})
+	}
+	entries = append(entries, Entry{Path: "/u/{user}", URL: server.URL + "/users/{user}"})
+
+	report := Check(context.Background(), NewMemoryStoreFromEntries(entries), CheckOptions{
+		Slow:         100 * time.Millisecond,
+		MaxRedirects: 3,
+		Client:       server.Client(),
+	})
+
+	tests := []struct {
+		path       string
+		method     string
+		status     int
+		redirects  int
+		broken     bool
+		slow       bool
+		redirected bool
+		skipped    bool
+	}{
+		{path: "/broken", method: http.MethodGet, status: 500, broken: true},
+		{path: "/loop", method: http.MethodGet, redirects: 3, broken: true,
+			redirected: true},
+		{path: "/moved", method: http.MethodHead, status: 200, redirects: 1,
+			redirected: true},
+		{path: "/no-head", method: http.MethodGet, status: 200},
+		{path: "/ok", method: http.MethodHead, status: 200},
+		{path: "/slow", method: http.MethodHead, status: 200, slow: true},
+		{path: "/u/{user}", skipped: true},
+	}
+	if len(report.Results) != len(tests) {
+		t.Fatalf("got %d results, want %d", len(report.Results), len(tests))
+	}
+	for i, tt := range tests {
+		got := report.Results[i]
+		if got.Path != tt.path {
+			t.Errorf("result %d is for %s, want %s", i, got.Path, tt.path)
+			continue
+		}
+		if got.Method != tt.method || got.Status != tt.status ||
+			got.Redirects != tt.redirects || got.Broken != tt.broken ||
+			got.Slow != tt.slow || got.Redirected != tt.redirected ||
+			got.Skipped != tt.skipped {
+			t.Errorf("%s: got %+v, want %+v", tt.path, got, tt)
+		}
+	}
+
+	if report.Checked != 6 || report.Broken != 2 || report.Slow != 1 ||
+		report.Redirected != 2 {
+		t.Errorf("report counts %d checked, %d broken, %d slow, %d redirected, "+
+			"want 6, 2, 1, 2", report.Checked, report.Broken, report.Slow,
+			report.Redirected)
+	}
+}
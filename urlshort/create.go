@@ -0,0 +1,219 @@
+package urlshort
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	base62Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	// Length of generated codes, grown by one each time this many
+	// consecutive collisions are seen
+	defaultCodeLength = 6
+	maxCodeAttempts   = 5
+)
+
+// Vanity codes may be made up of letters, digits, '-' and '_' only
+var vanityCodeRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// CreateRequest is the JSON body accepted by CreateHandler.
+type CreateRequest struct {
+	URL  string `json:"url"`
+	Code string `json:"code,omitempty"` // Optional vanity code
+}
+
+// CreateResponse is the JSON body returned by CreateHandler on success.
+type CreateResponse struct {
+	Code     string `json:"code"`
+	Path     string `json:"path"`
+	ShortURL string `json:"short_url"`
+	URL      string `json:"url"`
+}
+
+// CreateHandler returns an http.HandlerFunc that creates short links in the
+// given store, meant to be mounted at a path like /api/links. It accepts a
+// POST with a CreateRequest body and responds with 201 and a CreateResponse.
+// A random base62 code is generated unless a vanity code is requested, in
+// which case 409 is returned if the code is already taken (in any source
+// served before the store, if it is guarded with GuardShadowed).
+//
+// Short URLs in the response are formed from baseURL (for example
+// "https://go.example.com"), or from the request's own host if it is empty.
+func CreateHandler(store Store, baseURL string) http.HandlerFunc {
+	return CreateHandlerWithOptions(store, baseURL, Options{})
+}
+
+// CreateHandlerWithOptions is the same as CreateHandler but with its
+// behavior adjusted by the given options. Vanity codes among the options'
+// ReservedCodes are refused with 409, and are never generated.
+func CreateHandlerWithOptions(store Store, baseURL string, opts Options) http.HandlerFunc {
+	// Serializes the check for a free code with storing it
+	var mu sync.Mutex
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSONError(w, http.StatusMethodNotAllowed, "only POST is allowed")
+			return
+		}
+
+		var req CreateRequest
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+			return
+		}
+		if err := checkDestination(req.URL); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Code != "" && !vanityCodeRegexp.MatchString(req.Code) {
+			writeJSONError(w, http.StatusBadRequest,
+				"code may only contain letters, digits, '-' and '_'")
+			return
+		}
+
+		mu.Lock()
+		code, err := reserveCode(store, req.Code, opts.ReservedCodes)
+		if err == nil {
+			now := time.Now()
+			err = storeChange(store, "api", []Entry{
+				{Path: "/" + code, URL: req.URL, Created: &now},
+			}, nil)
+		}
+		mu.Unlock()
+
+		switch {
+		case err == errCodeTaken || err == ErrShadowed:
+			writeJSONError(w, http.StatusConflict,
+				fmt.Sprintf("code '%s' is already in use", req.Code))
+			return
+		case err == errCodeReserved:
+			writeJSONError(w, http.StatusConflict,
+				fmt.Sprintf("code '%s' is reserved", req.Code))
+			return
+		case err != nil:
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		base := baseURL
+		if base == "" {
+			base = requestBaseURL(r)
+		}
+		writeJSON(w, http.StatusCreated, CreateResponse{
+			Code:     code,
+			Path:     "/" + code,
+			ShortURL: strings.TrimSuffix(base, "/") + "/" + code,
+			URL:      req.URL,
+		})
+	})
+}
+
+var (
+	errCodeTaken    = errors.New("code already in use")
+	errCodeReserved = errors.New("code reserved")
+)
+
+// reserveCode returns the vanity code if it is free and not one of the
+// reserved codes, else a newly generated code that is neither in the store
+// nor reserved
+func reserveCode(store Store, vanity string, reserved []string) (string, error) {
+	if vanity != "" {
+		if isReserved(vanity, reserved) {
+			return "", errCodeReserved
+		}
+		if _, ok := store.Lookup("/" + vanity); ok {
+			return "", errCodeTaken
+		}
+		return vanity, nil
+	}
+
+	for length := defaultCodeLength; ; length++ {
+		for i := 0; i < maxCodeAttempts; i++ {
+			code, err := randomCode(length)
+			if err != nil {
+				return "", err
+			}
+			if _, ok := store.Lookup("/" + code); !ok && !isReserved(code, reserved) {
+				return code, nil
+			}
+		}
+	}
+}
+
+// isReserved returns whether the code is one of the reserved codes
+func isReserved(code string, reserved []string) bool {
+	for _, r := range reserved {
+		if code == r {
+			return true
+		}
+	}
+	return false
+}
+
+// randomCode returns a random base62 string of the given length
+func randomCode(length int) (string, error) {
+	var sb strings.Builder
+	sb.Grow(length)
+
+	max := big.NewInt(int64(len(base62Chars)))
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(base62Chars[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// checkDestination returns an error unless the given string is an absolute
+// http or https URL
+func checkDestination(dest string) error {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("url must be absolute with an http or https scheme")
+	}
+	if u.Host == "" {
+		return errors.New("url must have a host")
+	}
+	return nil
+}
+
+// requestBaseURL returns the scheme and host the request was made to
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// writeJSON writes the given value as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// writeJSONError writes a JSON response of the form {"error": msg}
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,89 @@
+package urlshort
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateHandler(t *testing.T) {
+	store := NewMemoryStoreFromEntries([]Entry{{Path: "/taken", URL: "https://example.com"}})
+	earlier := []Source{{Name: "file", Store: NewMemoryStoreFromEntries([]Entry{
+		{Path: "/from-file", URL: "https://example.com"},
+	})}}
+	handler := CreateHandlerWithOptions(GuardShadowed(store, earlier), "https://go.example.com",
+		Options{ReservedCodes: []string{"metrics", "api"}})
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"vanity", http.MethodPost, `{"url": "https://example.com/x", "code": "mine"}`,
+			http.StatusCreated},
+		{"generated", http.MethodPost, `{"url": "https://example.com/y"}`, http.StatusCreated},
+		{"vanity taken", http.MethodPost, `{"url": "https://example.com", "code": "taken"}`,
+			http.StatusConflict},
+		{"vanity created before", http.MethodPost,
+			`{"url": "https://example.com", "code": "mine"}`, http.StatusConflict},
+		{"vanity in an earlier source", http.MethodPost,
+			`{"url": "https://example.com", "code": "from-file"}`, http.StatusConflict},
+		{"reserved", http.MethodPost, `{"url": "https://example.com", "code": "metrics"}`,
+			http.StatusConflict},
+		{"bad json", http.MethodPost, `{"url": `, http.StatusBadRequest},
+		{"unknown field", http.MethodPost, `{"uri": "https://example.com"}`,
+			http.StatusBadRequest},
+		{"relative url", http.MethodPost, `{"url": "/somewhere"}`, http.StatusBadRequest},
+		{"bad scheme", http.MethodPost, `{"url": "javascript:alert(1)"}`, http.StatusBadRequest},
+		{"no host", http.MethodPost, `{"url": "https:///path"}`, http.StatusBadRequest},
+		{"bad code", http.MethodPost, `{"url": "https://example.com", "code": "a/b"}`,
+			http.StatusBadRequest},
+		{"long code", http.MethodPost,
+			`{"url": "https://example.com", "code": "` + strings.Repeat("a", 65) + `"}`,
+			http.StatusBadRequest},
+		{"get", http.MethodGet, "", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(tt.method, "/api/links", strings.NewReader(tt.body)))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if w.Code != http.StatusCreated {
+				return
+			}
+
+			var resp CreateResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.ShortURL != "https://go.example.com"+resp.Path {
+				t.Errorf("short URL %s for path %s", resp.ShortURL, resp.Path)
+			}
+			if e, ok := store.Lookup(resp.Path); !ok || e.URL != resp.URL {
+				t.Errorf("stored entry %+v, want one redirecting to %s", e, resp.URL)
+			}
+		})
+	}
+}
+
+func TestReserveCode(t *testing.T) {
+	store := NewMemoryStoreFromEntries(nil)
+	reserved := []string{"api"}
+
+	if _, err := reserveCode(store, "api", reserved); err != errCodeReserved {
+		t.Errorf("reserveCode(api) = %v, want %v", err, errCodeReserved)
+	}
+	code, err := reserveCode(store, "", reserved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != defaultCodeLength || !vanityCodeRegexp.MatchString(code) {
+		t.Errorf("generated code %q", code)
+	}
+}
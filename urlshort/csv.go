@@ -0,0 +1,87 @@
+package urlshort
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Header row written by WriteCSV, and skipped by ParseCSV if present
+var csvHeader = []string{"path", "url", "description"}
+
+// ParseCSV parses entries from CSV rows in the format:
+//
+//	path,url,description
+//	/some-path,https://www.some-url.com/demo,Demo of the site
+//
+// The header row and description column are optional, and lines starting
+// with '#' are comments. The path may be preceded by a host as in
+// ParseFlat. Rows without a path are reported as errors; see ImportCSV to
+// derive paths for them instead.
+func ParseCSV(data []byte) ([]Entry, error) {
+	return ImportCSV(data, ImportOptions{})
+}
+
+// ImportCSV is the same as ParseCSV but with the handling of rows without
+// a path given by the options.
+func ImportCSV(data []byte, opts ImportOptions) ([]Entry, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	var entries []Entry
+	var titles []string
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := r.FieldPos(0)
+
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), csvHeader[0]) {
+			continue
+		}
+		if len(record) < 2 || len(record) > len(csvHeader) {
+			return nil, fmt.Errorf("line %d: expected path,url[,description]", line)
+		}
+
+		e := Entry{
+			Path: strings.TrimSpace(record[0]),
+			URL:  strings.TrimSpace(record[1]),
+		}
+		if len(record) > 2 {
+			e.Description = strings.TrimSpace(record[2])
+		}
+		e.Host, e.Path = SplitKey(e.Path)
+		if e.URL == "" {
+			return nil, fmt.Errorf("line %d: row has no url", line)
+		}
+		if e.Path == "" && !opts.DerivePaths {
+			return nil, fmt.Errorf("line %d: row has no path", line)
+		}
+		entries = append(entries, e)
+		titles = append(titles, e.Description)
+	}
+
+	derivePaths(entries, titles, opts.Existing)
+	return entries, nil
+}
+
+// WriteCSV writes the path (preceded by the host, if any), URL and
+// description of the entries as CSV rows after a header row. Other fields
+// of the entries are not written.
+func WriteCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, e := range entries {
+		cw.Write([]string{e.Key(), e.URL, e.Description})
+	}
+	cw.Flush()
+	return cw.Error()
+}
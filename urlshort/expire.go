@@ -0,0 +1,76 @@
+package urlshort
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Dead returns whether the entry has expired or been used up as of the
+// given time, and so should no longer redirect.
+func (e Entry) Dead(now time.Time) bool {
+	if e.ExpiresAt != nil && !now.Before(*e.ExpiresAt) {
+		return true
+	}
+	return e.MaxUses > 0 && e.Uses >= e.MaxUses
+}
+
+// takeUse counts a use of the limited use entry with the given key, returning
+// false if it has no uses left. The entry is looked up again under the given
+// lock so that concurrent requests cannot both take its last use.
+func takeUse(store Store, mu *sync.Mutex, key string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	entry, ok := store.Lookup(key)
+	if !ok || entry.Dead(time.Now()) {
+		return false
+	}
+
+	entry.Uses++
+	if err := store.Put(entry); err != nil {
+		// Better to let the use through than fail a valid redirect
+		log.Printf("urlshort: error counting use of %s: %v", key, err)
+	}
+	return true
+}
+
+// Sweep deletes every entry in the store that is dead as of the given time,
+// returning how many were deleted.
+func Sweep(store Store, now time.Time) (int, error) {
+	deleted := 0
+	for _, e := range store.List() {
+		if !e.Dead(now) {
+			continue
+		}
+		if err := store.Delete(e.Key()); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// StartSweeper runs Sweep on the store every interval in the background
+// until the returned stop function is called.
+func StartSweeper(store Store, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if _, err := Sweep(store, now); err != nil {
+					log.Printf("urlshort: error sweeping dead entries: %v", err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
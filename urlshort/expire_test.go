@@ -0,0 +1,192 @@
+package urlshort
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDead(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Second), now.Add(time.Second)
+
+	tests := []struct {
+		name  string
+		entry Entry
+		want  bool
+	}{
+		{"no limits", Entry{}, false},
+		{"expires later", Entry{ExpiresAt: &after}, false},
+		{"expires now", Entry{ExpiresAt: &now}, true},
+		{"expired", Entry{ExpiresAt: &before}, true},
+		{"uses left", Entry{MaxUses: 2, Uses: 1}, false},
+		{"used up", Entry{MaxUses: 2, Uses: 2}, true},
+		{"uses without limit", Entry{Uses: 5}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.entry.Dead(now); got != tt.want {
+			t.Errorf("%s: Dead() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStoreHandlerGone(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	store := NewMemoryStoreFromEntries([]Entry{
+		{Path: "/expired", URL: "https://example.com", ExpiresAt: &past},
+		{Path: "/used", URL: "https://example.com", MaxUses: 1, Uses: 1},
+	})
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	for _, path := range []string{"/expired", "/used"} {
+		w := httptest.NewRecorder()
+		StoreHandler(store, fallback)(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusGone {
+			t.Errorf("%s: status %d, want %d", path, w.Code, http.StatusGone)
+		}
+
+		w = httptest.NewRecorder()
+		handler := StoreHandlerWithOptions(store, fallback, Options{GoneToFallback: true})
+		handler(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusTeapot {
+			t.Errorf("%s with GoneToFallback: status %d, want the fallback's %d",
+				path, w.Code, http.StatusTeapot)
+		}
+	}
+}
+
+func TestStoreHandlerMaxUses(t *testing.T) {
+	store := NewMemoryStoreFromEntries([]Entry{
+		{Path: "/twice", URL: "https://example.com", MaxUses: 2},
+	})
+	handler := StoreHandler(store, http.NotFoundHandler())
+
+	for i, want := range []int{http.StatusSeeOther, http.StatusSeeOther, http.StatusGone} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/twice", nil))
+		if w.Code != want {
+			t.Errorf("request %d: status %d, want %d", i+1, w.Code, want)
+		}
+	}
+	if e, _ := store.Lookup("/twice"); e.Uses != 2 {
+		t.Errorf("uses counted %d, want 2", e.Uses)
+	}
+
+	// Previews asked for do not use the entry up
+	store.Put(Entry{Path: "/once", URL: "https://example.com", MaxUses: 1})
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/once+", nil))
+	if e, _ := store.Lookup("/once"); w.Code != http.StatusOK || e.Uses != 0 {
+		t.Errorf("preview: status %d and %d uses, want %d and 0",
+			w.Code, e.Uses, http.StatusOK)
+	}
+}
+
+func TestTakeUseConcurrent(t *testing.T) {
+	store := NewMemoryStoreFromEntries([]Entry{
+		{Path: "/limited", URL: "https://example.com", MaxUses: 10},
+	})
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	taken := make(chan bool, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			taken <- takeUse(store, &mu, "/limited")
+		}()
+	}
+	wg.Wait()
+	close(taken)
+
+	n := 0
+	for ok := range taken {
+		if ok {
+			n++
+		}
+	}
+	if n != 10 {
+		t.Errorf("%d uses taken, want 10", n)
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	want := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		parse func([]byte) ([]Entry, error)
+		data  string
+	}{
+		{"yaml", ParseYAML, `
+- path: /a
+  url: https://example.com
+  expires_at: 2030-01-02T03:04:05Z
+  max_uses: 3
+`},
+		{"json", ParseJSON, `[{"path": "/a", "url": "https://example.com",
+			"expires_at": "2030-01-02T03:04:05Z", "max_uses": 3}]`},
+	}
+
+	for _, tt := range tests {
+		entries, err := tt.parse([]byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(entries) != 1 {
+			t.Errorf("%s: got %d entries, want 1", tt.name, len(entries))
+			continue
+		}
+		e := entries[0]
+		if e.ExpiresAt == nil || !e.ExpiresAt.Equal(want) {
+			t.Errorf("%s: expires_at %v, want %v", tt.name, e.ExpiresAt, want)
+		}
+		if e.MaxUses != 3 {
+			t.Errorf("%s: max_uses %d, want 3", tt.name, e.MaxUses)
+		}
+	}
+
+	if _, err := ParseYAML([]byte("- {path: /a, url: https://example.com, expires_at: soon}")); err == nil {
+		t.Error("ParseYAML() accepted expires_at: soon")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	store := NewMemoryStoreFromEntries([]Entry{
+		{Path: "/alive", URL: "https://example.com", MaxUses: 2, Uses: 1},
+		{Path: "/expired", URL: "https://example.com", ExpiresAt: &past},
+		{Path: "/used", URL: "https://example.com", MaxUses: 1, Uses: 1},
+	})
+
+	if n, err := Sweep(store, now); n != 2 || err != nil {
+		t.Fatalf("Sweep() = %d, %v, want 2, nil", n, err)
+	}
+	if got := store.List(); len(got) != 1 || got[0].Path != "/alive" {
+		t.Errorf("entries left %v, want /alive only", got)
+	}
+}
+
+func TestStartSweeper(t *testing.T) {
+	store := NewMemoryStoreFromEntries([]Entry{
+		{Path: "/used", URL: "https://example.com", MaxUses: 1, Uses: 1},
+	})
+	stop := StartSweeper(store, time.Millisecond)
+	defer stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(store.List()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("sweeper did not delete the used up entry")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop() // Stopping twice is fine
+}
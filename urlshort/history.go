@@ -0,0 +1,462 @@
+package urlshort
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Authors of revisions not made on behalf of anyone in particular
+const (
+	AuthorSystem  = "system"  // Plain Put and Delete calls, such as sweeping
+	AuthorStartup = "startup" // Changes found when the history is opened
+)
+
+// Kinds of Change
+const (
+	ChangeAdded   = "added"
+	ChangeUpdated = "updated"
+	ChangeRemoved = "removed"
+)
+
+// Change is the difference one revision makes to a single entry.
+type Change struct {
+	Key    string `json:"key"`
+	Kind   string `json:"kind"` // One of the Change constants
+	Before *Entry `json:"before,omitempty"`
+	After  *Entry `json:"after,omitempty"`
+}
+
+// Revision is a single change to the entries of a HistoryStore.
+type Revision struct {
+	ID      int       `json:"id"` // Counting from 1
+	Author  string    `json:"author"`
+	Time    time.Time `json:"time"`
+	Changes []Change  `json:"changes"`
+}
+
+// HistoryStore is a Store that records every change made to the entries of
+// the Store it wraps as a Revision, appended to a history file if it has
+// one, so that changes can be listed and rolled back. Changes to only the
+// use count of an entry are not recorded.
+type HistoryStore struct {
+	Store
+	mu        sync.Mutex // Serializes changes
+	revisions []Revision
+	file      *os.File
+}
+
+var errNoRevision = errors.New("no such revision")
+
+// NewHistoryStore returns a HistoryStore wrapping the given store, keeping
+// its history in memory only. The entries already in the store are
+// recorded as the first revision.
+func NewHistoryStore(store Store) *HistoryStore {
+	h := &HistoryStore{Store: store}
+	h.reconcile() // Cannot fail without a file
+	return h
+}
+
+// OpenHistoryStore returns a HistoryStore wrapping the given store, with
+// its history read from and appended to the given file (created if
+// necessary). If the store's entries differ from those the history ends
+// with, as when the store was changed without it, a revision is recorded
+// to bring the history up to date. Close must be called once the store is
+// no longer needed.
+func OpenHistoryStore(store Store, fileName string) (*HistoryStore, error) {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	h := &HistoryStore{Store: store, file: file}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20) // Revisions of whole tables can be long
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		var rev Revision
+		if err = json.Unmarshal(scanner.Bytes(), &rev); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error in history %s at line %d: %v",
+				fileName, lineNum, err)
+		}
+		h.revisions = append(h.revisions, rev)
+	}
+	if err = scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if err = h.reconcile(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// Close closes the history file, if any.
+func (h *HistoryStore) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == nil {
+		return nil
+	}
+	return h.file.Close()
+}
+
+// Match lets resolve use the compiled routes of the wrapped store, if any
+func (h *HistoryStore) Match(host, path string) (Entry, string, bool) {
+	return matchStore(h.Store, host, path)
+}
+
+func (h *HistoryStore) Put(e Entry) error {
+	return h.PutBy(AuthorSystem, e)
+}
+
+func (h *HistoryStore) Delete(key string) error {
+	return h.DeleteBy(AuthorSystem, key)
+}
+
+// PutBy is the same as Put but records the given author for the change.
+func (h *HistoryStore) PutBy(author string, e Entry) error {
+	return h.Apply(author, []Entry{e}, nil)
+}
+
+// DeleteBy is the same as Delete but records the given author for the
+// change.
+func (h *HistoryStore) DeleteBy(author string, key string) error {
+	return h.Apply(author, nil, []string{key})
+}
+
+// Apply puts the given entries and deletes the entries with the given keys
+// as a single revision by the given author.
+func (h *HistoryStore) Apply(author string, puts []Entry, deletes []string) error {
+	// Check the entries first, and record them as the store will keep them
+	prepared := make([]Entry, len(puts))
+	for i, e := range puts {
+		var err error
+		if prepared[i], err = prepareEntry(e); err != nil {
+			return err
+		}
+	}
+	puts = prepared
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Work out the changes against the table as it will be, so that the
+	// same key put twice or put and deleted only counts once
+	table := make(map[string]Entry)
+	for _, e := range h.List() {
+		table[e.Key()] = e
+	}
+	after := make(map[string]Entry, len(table))
+	for k, e := range table {
+		after[k] = e
+	}
+	for _, key := range deletes {
+		delete(after, key)
+	}
+	for _, e := range puts {
+		after[e.Key()] = e
+	}
+
+	changes := diffTables(table, after)
+	if len(changes) == 0 {
+		return nil
+	}
+	if onlyUses(changes) {
+		return h.applyChanges(changes) // Not worth a revision
+	}
+	return h.record(author, changes)
+}
+
+// Revisions returns the revisions of the store, oldest first. Given a key,
+// only the revisions changing that entry are returned, with only the
+// changes to it.
+func (h *HistoryStore) Revisions(key string) []Revision {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var list []Revision
+	for _, rev := range h.revisions {
+		if key == "" {
+			list = append(list, rev)
+			continue
+		}
+		for _, c := range rev.Changes {
+			if c.Key == key {
+				rev.Changes = []Change{c}
+				list = append(list, rev)
+				break
+			}
+		}
+	}
+	return list
+}
+
+// Diff returns the changes between the entries as they were after the
+// revisions with the given IDs (0 being before the first), sorted by key.
+// Given a key, only the change to that entry is returned, if any.
+func (h *HistoryStore) Diff(from, to int, key string) ([]Change, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	before, err := h.tableAt(from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := h.tableAt(to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := diffTables(before, after)
+	if key == "" {
+		return changes, nil
+	}
+	for _, c := range changes {
+		if c.Key == key {
+			return []Change{c}, nil
+		}
+	}
+	return nil, nil
+}
+
+// Rollback restores the entries to what they were after the revision with
+// the given ID (0 being before the first), recording that as a new revision
+// by the given author, which is returned. Given a key, only that entry is
+// restored. Use counts are left as they are. If nothing needs restoring no
+// revision is recorded and nil is returned.
+func (h *HistoryStore) Rollback(author string, id int, key string) (*Revision, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	target, err := h.tableAt(id)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]Entry)
+	for _, e := range h.List() {
+		current[e.Key()] = e
+	}
+
+	if key != "" {
+		restored := make(map[string]Entry, len(current))
+		for k, e := range current {
+			restored[k] = e
+		}
+		if e, ok := target[key]; ok {
+			restored[key] = e
+		} else {
+			delete(restored, key)
+		}
+		target = restored
+	}
+	for k, e := range target {
+		if cur, ok := current[k]; ok {
+			e.Uses = cur.Uses
+			target[k] = e
+		}
+	}
+
+	changes := diffTables(current, target)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if err = h.record(author, changes); err != nil {
+		return nil, err
+	}
+	rev := h.revisions[len(h.revisions)-1]
+	return &rev, nil
+}
+
+// reconcile records a revision by AuthorStartup with any differences
+// between the entries the history ends with and those in the store
+func (h *HistoryStore) reconcile() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	recorded, _ := h.tableAt(len(h.revisions))
+	stored := make(map[string]Entry)
+	for _, e := range h.List() {
+		stored[e.Key()] = e
+	}
+
+	changes := diffTables(recorded, stored)
+	if len(changes) == 0 || onlyUses(changes) {
+		return nil
+	}
+	return h.appendRevision(Revision{
+		ID:      len(h.revisions) + 1,
+		Author:  AuthorStartup,
+		Time:    time.Now(),
+		Changes: changes,
+	})
+}
+
+// record applies the given changes to the wrapped store and only then
+// appends a revision with them to the history, so that changes the store
+// refuses are never recorded. The changes are undone if recording fails.
+func (h *HistoryStore) record(author string, changes []Change) error {
+	if err := h.applyChanges(changes); err != nil {
+		return err
+	}
+	err := h.appendRevision(Revision{
+		ID:      len(h.revisions) + 1,
+		Author:  author,
+		Time:    time.Now(),
+		Changes: changes,
+	})
+	if err != nil {
+		h.undoChanges(changes)
+	}
+	return err
+}
+
+// appendRevision writes the revision to the history file, if any, and
+// adds it to the revisions in memory
+func (h *HistoryStore) appendRevision(rev Revision) error {
+	if h.file != nil {
+		line, err := json.Marshal(rev)
+		if err != nil {
+			return err
+		}
+		if _, err = h.file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		if err = h.file.Sync(); err != nil {
+			return err
+		}
+	}
+	h.revisions = append(h.revisions, rev)
+	return nil
+}
+
+// applyChanges makes the given changes to the wrapped store, undoing those
+// already made if one fails
+func (h *HistoryStore) applyChanges(changes []Change) error {
+	for i, c := range changes {
+		if err := h.setEntry(c.Key, c.After); err != nil {
+			h.undoChanges(changes[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// undoChanges reverts the given changes made to the wrapped store, latest
+// first, as far as it can
+func (h *HistoryStore) undoChanges(changes []Change) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		h.setEntry(changes[i].Key, changes[i].Before)
+	}
+}
+
+// setEntry puts the given entry in the wrapped store, or deletes the entry
+// with the given key if nil
+func (h *HistoryStore) setEntry(key string, e *Entry) error {
+	if e != nil {
+		return h.Store.Put(*e)
+	}
+	return h.Store.Delete(key)
+}
+
+// tableAt returns the entries as they were after the revision with the
+// given ID, keyed by their keys
+func (h *HistoryStore) tableAt(id int) (map[string]Entry, error) {
+	if id < 0 || id > len(h.revisions) {
+		return nil, errNoRevision
+	}
+	table := make(map[string]Entry)
+	for _, rev := range h.revisions[:id] {
+		for _, c := range rev.Changes {
+			if c.After != nil {
+				table[c.Key] = *c.After
+			} else {
+				delete(table, c.Key)
+			}
+		}
+	}
+	return table, nil
+}
+
+// diffTables returns the changes turning one table of entries into the
+// other, sorted by key
+func diffTables(before, after map[string]Entry) []Change {
+	var changes []Change
+	for k, b := range before {
+		b := b
+		if a, ok := after[k]; !ok {
+			changes = append(changes, Change{Key: k, Kind: ChangeRemoved, Before: &b})
+		} else if !reflect.DeepEqual(sameTimes(a), sameTimes(b)) {
+			a := a
+			changes = append(changes, Change{Key: k, Kind: ChangeUpdated,
+				Before: &b, After: &a})
+		}
+	}
+	for k, a := range after {
+		a := a
+		if _, ok := before[k]; !ok {
+			changes = append(changes, Change{Key: k, Kind: ChangeAdded, After: &a})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// sameTimes returns the entry with its times in UTC and without monotonic
+// clock readings, so that equal times compare equal once read back
+func sameTimes(e Entry) Entry {
+	for _, t := range []**time.Time{&e.Created, &e.ExpiresAt} {
+		if *t != nil {
+			utc := (*t).UTC().Round(0)
+			*t = &utc
+		}
+	}
+	return e
+}
+
+// onlyUses returns whether the changes are all updates of use counts
+func onlyUses(changes []Change) bool {
+	for _, c := range changes {
+		if c.Kind != ChangeUpdated {
+			return false
+		}
+		if !sameButUses(*c.Before, *c.After) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameButUses returns whether the entries are the same other than their
+// use counts
+func sameButUses(a, b Entry) bool {
+	b.Uses = a.Uses
+	return reflect.DeepEqual(sameTimes(a), sameTimes(b))
+}
+
+// storeChange puts and deletes entries of the store on behalf of the given
+// author, as a single revision if the store keeps history
+func storeChange(store Store, author string, puts []Entry, deletes []string) error {
+	if h, ok := store.(*HistoryStore); ok {
+		return h.Apply(author, puts, deletes)
+	}
+	for _, e := range puts {
+		if err := store.Put(e); err != nil {
+			return err
+		}
+	}
+	for _, key := range deletes {
+		if err := store.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package urlshort
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHistoryRefusedChangeNotRecorded(t *testing.T) {
+	h, err := OpenHistoryStore(NewMemoryStoreFromEntries(nil),
+		filepath.Join(t.TempDir(), "history.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	if err = h.PutBy("alice", Entry{Path: "/good", URL: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	err = h.Apply("alice", []Entry{
+		{Path: "/other", URL: "https://example.com"},
+		{Path: "/bad", URL: "https://example.com", Status: 200},
+	}, []string{"/good"})
+	if err == nil {
+		t.Fatal("Apply() accepted status 200")
+	}
+
+	if got := len(h.Revisions("")); got != 1 {
+		t.Errorf("got %d revisions, want 1", got)
+	}
+	if _, ok := h.Lookup("/good"); !ok {
+		t.Error("entry deleted by the refused change is gone")
+	}
+	if _, ok := h.Lookup("/other"); ok {
+		t.Error("entry put by the refused change is there")
+	}
+}
+
+func TestAdminAuthorFromToken(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "tokens")
+	err := ioutil.WriteFile(fileName, []byte("named write alice\nanonymous write\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := LoadTokens(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHistoryStore(NewMemoryStoreFromEntries(nil))
+	handler := AdminHandler(h, tokens)
+
+	for i, token := range []string{"named", "anonymous"} {
+		body := `{"path": "/` + token + `", "url": "https://example.com"}`
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("X-Author", "mallory")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("POST with %s token: status %d: %s", token, w.Code, w.Body)
+		}
+
+		want := []string{"alice", tokenFingerprint("anonymous")}[i]
+		revs := h.Revisions("/" + token)
+		if len(revs) != 1 || revs[0].Author != want {
+			t.Errorf("%s token: revisions %+v, want one by %s", token, revs, want)
+		}
+	}
+}
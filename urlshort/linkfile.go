@@ -0,0 +1,433 @@
+package urlshort
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Errors returned when editing a LinkFile
+var (
+	ErrEntryExists   = errors.New("urlshort: an entry with that path already exists")
+	ErrEntryNotFound = errors.New("urlshort: no entry with that path")
+)
+
+// LinkFile is a file of entries in one of the formats of ParserFor, or the
+// file of a JSONFileStore, loaded for editing offline. Edits keep the order
+// of the entries. In YAML files (with entries listed rather than grouped by
+// host) and flat files they also keep the comments and layout of the file,
+// touching only the lines of the entries edited; other files are written
+// out in full by Save.
+type LinkFile struct {
+	fileName string
+	parse    func([]byte) ([]Entry, error)
+	write    func(io.Writer, []Entry) error               // Nil for flat files
+	render   func(e Entry, indent string) (string, error) // Nil unless edited as text
+	entries  []Entry
+
+	// Lines of the file and where the entries are in them, if edited as
+	// text (nil if the file is written out in full)
+	lines []string
+	spans []lineSpan
+}
+
+// lineSpan locates an entry in the lines of a file: it starts at line
+// start, preceded by its comments from line lead, and ends before line end
+type lineSpan struct {
+	lead, start, end int
+}
+
+// OpenLinkFile loads the entries in the given file, which need not exist
+// yet, for editing. Its format is picked by its extension, as for
+// ParserFor.
+func OpenLinkFile(fileName string) (*LinkFile, error) {
+	return OpenLinkFileAs(fileName, filepath.Ext(fileName))
+}
+
+// OpenLinkFileAs is the same as OpenLinkFile but reads and writes the file
+// in the format of the given extension (such as ".json"), whatever its own.
+func OpenLinkFileAs(fileName, ext string) (*LinkFile, error) {
+	f := &LinkFile{fileName: fileName, parse: ParserFor(ext)}
+	switch strings.ToLower(ext) {
+	case ".json":
+		f.write = writeJSONEntries
+	case ".yaml", ".yml":
+		f.write, f.render = WriteYAML, renderYAML
+	case ".csv":
+		f.write = WriteCSV
+	case ".html", ".htm":
+		f.write = WriteBookmarks
+	default:
+		f.render = renderFlat
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if f.entries, err = f.parse(data); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", fileName, err)
+		}
+	}
+
+	if f.render != nil {
+		f.lines = splitLines(string(data))
+		f.spans = f.locate(f.lines, f.entries)
+		if f.spans == nil {
+			f.lines = nil
+		}
+	}
+	return f, nil
+}
+
+// Entries returns the entries of the file, in order.
+func (f *LinkFile) Entries() []Entry {
+	return append([]Entry(nil), f.entries...)
+}
+
+// Lookup returns the entry with the given key (see Entry.Key), if any.
+func (f *LinkFile) Lookup(key string) (Entry, bool) {
+	if i := f.indexOf(key); i >= 0 {
+		return f.entries[i], true
+	}
+	return Entry{}, false
+}
+
+// Add adds the entry at the end of the file, unless an entry with its key
+// is already there.
+func (f *LinkFile) Add(e Entry) error {
+	if e.Path == "" || e.URL == "" {
+		return ErrEmptyEntry
+	}
+	if f.indexOf(e.Key()) >= 0 {
+		return ErrEntryExists
+	}
+
+	entries := append(f.Entries(), e)
+	if f.lines == nil {
+		return f.rewrite(entries)
+	}
+
+	at := len(f.lines)
+	if n := len(f.spans); n > 0 {
+		at = f.spans[n-1].end
+	}
+	indent := ""
+	if len(f.spans) > 0 {
+		first := f.lines[f.spans[0].start]
+		indent = first[:len(first)-len(strings.TrimLeft(first, " "))]
+	}
+	added, err := f.render(e, indent)
+	if err != nil {
+		return err
+	}
+	return f.edit(entries, at, at, splitLines(added))
+}
+
+// Remove removes the entry with the given key, along with the comments
+// just before it.
+func (f *LinkFile) Remove(key string) error {
+	i := f.indexOf(key)
+	if i < 0 {
+		return ErrEntryNotFound
+	}
+
+	entries := append(f.Entries()[:i], f.entries[i+1:]...)
+	if f.lines == nil {
+		return f.rewrite(entries)
+	}
+	return f.edit(entries, f.spans[i].lead, f.spans[i].end, nil)
+}
+
+// Move gives the entry with the from key the host and path of the to key,
+// keeping its place in the file, unless an entry with the to key is
+// already there.
+func (f *LinkFile) Move(from, to string) error {
+	i := f.indexOf(from)
+	if i < 0 {
+		return ErrEntryNotFound
+	}
+	if f.indexOf(to) >= 0 {
+		return ErrEntryExists
+	}
+
+	e := f.entries[i]
+	e.Host, e.Path = SplitKey(to)
+	entries := f.Entries()
+	entries[i] = e
+	if f.lines == nil {
+		return f.rewrite(entries)
+	}
+
+	// Changing just the path and host keeps any comments within the
+	// entry, which rendering it again would not
+	span := f.spans[i]
+	moved := append([]string(nil), f.lines[span.start:span.end]...)
+	ok := setYAMLValue(moved, "path", e.Path)
+	if ok && e.Host != f.entries[i].Host && !setYAMLValue(moved, "host", e.Host) {
+		moved, ok = addYAMLValue(moved, "host", e.Host)
+	}
+	if !ok || !f.parsesTo(moved, e) {
+
+		first := f.lines[span.start]
+		indent := first[:len(first)-len(strings.TrimLeft(first, " "))]
+		rendered, err := f.render(e, indent)
+		if err != nil {
+			return err
+		}
+		moved = splitLines(rendered)
+	}
+	return f.edit(entries, span.start, span.end, moved)
+}
+
+// Save writes the file, replacing it only once it has been written in
+// full.
+func (f *LinkFile) Save() error {
+	var buf bytes.Buffer
+	if f.lines != nil {
+		buf.WriteString(strings.Join(f.lines, ""))
+	} else if err := f.write(&buf, f.entries); err != nil {
+		return err
+	}
+	return writeFileAtomic(f.fileName, buf.Bytes())
+}
+
+// indexOf returns the index of the entry with the given key, or -1
+func (f *LinkFile) indexOf(key string) int {
+	host, path := SplitKey(key)
+	key = EntryKey(host, path)
+	for i, e := range f.entries {
+		if e.Key() == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// edit replaces lines [from, to) of the file with the given ones, leaving
+// it with the given entries. If the new lines do not parse to those
+// entries, the file is written out in full instead, if it can be.
+func (f *LinkFile) edit(entries []Entry, from, to int, with []string) error {
+	lines := append([]string(nil), f.lines[:from]...)
+	if n := len(lines); n > 0 && len(with) > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		lines[n-1] += "\n"
+	}
+	lines = append(append(lines, with...), f.lines[to:]...)
+
+	if spans := f.locate(lines, entries); spans != nil {
+		parsed, err := f.parse([]byte(strings.Join(lines, "")))
+		if err == nil && sameEntries(parsed, entries) {
+			f.entries, f.lines, f.spans = parsed, lines, spans
+			return nil
+		}
+	}
+	return f.rewrite(entries)
+}
+
+// rewrite leaves the file with the given entries, to be written out in
+// full
+func (f *LinkFile) rewrite(entries []Entry) error {
+	if f.write == nil {
+		return fmt.Errorf("%s cannot hold the entry (only paths and urls)", f.fileName)
+	}
+	f.entries, f.lines, f.spans = entries, nil, nil
+	return nil
+}
+
+// locate returns where each of the given entries is in the given lines, or
+// nil if they cannot all be told apart: each must parse on its own, and
+// nothing but blank lines and comments may be outside of them
+func (f *LinkFile) locate(lines []string, entries []Entry) []lineSpan {
+	var starts []int
+	indent := -1
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		if isFiller(trimmed) {
+			continue
+		}
+		if f.write == nil { // Flat files have an entry per line
+			starts = append(starts, i)
+			continue
+		}
+		n := len(line) - len(trimmed)
+		if indent < 0 {
+			indent = n
+		}
+		if n > indent {
+			continue
+		}
+		if n < indent || !(strings.HasPrefix(trimmed, "- ") || strings.TrimSpace(trimmed) == "-") {
+			return nil // Not a plain list of entries
+		}
+		starts = append(starts, i)
+	}
+	if len(starts) != len(entries) {
+		return nil
+	}
+
+	spans := make([]lineSpan, len(starts))
+	prevEnd := 0
+	for i, start := range starts {
+		end := len(lines)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		for end-1 > start && isFiller(strings.TrimLeft(lines[end-1], " ")) {
+			end--
+		}
+		lead := start
+		for lead-1 >= prevEnd && strings.HasPrefix(strings.TrimSpace(lines[lead-1]), "#") {
+			lead--
+		}
+		if !f.parsesTo(lines[start:end], entries[i]) {
+			return nil
+		}
+		spans[i] = lineSpan{lead, start, end}
+		prevEnd = end
+	}
+	return spans
+}
+
+// parsesTo returns whether the given lines parse to just the given entry
+func (f *LinkFile) parsesTo(lines []string, e Entry) bool {
+	parsed, err := f.parse([]byte(strings.Join(lines, "")))
+	return err == nil && sameEntries(parsed, []Entry{e})
+}
+
+// isFiller returns whether the given line, without its indentation, is
+// blank or a comment
+func isFiller(trimmed string) bool {
+	trimmed = strings.TrimSpace(trimmed)
+	return trimmed == "" || strings.HasPrefix(trimmed, "#")
+}
+
+// sameEntries returns whether the given lists hold the same entries, as
+// written out (so times in different locations compare equal)
+func sameEntries(a, b []Entry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+// splitLines splits the given text into lines, each keeping its newline
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// renderYAML returns the entry as an item of a YAML list, indented as
+// given
+func renderYAML(e Entry, indent string) (string, error) {
+	yml, err := yaml.Marshal([]Entry{e})
+	if err != nil {
+		return "", err
+	}
+	lines := splitLines(string(yml))
+	for i := range lines {
+		lines[i] = indent + lines[i]
+	}
+	return strings.Join(lines, ""), nil
+}
+
+// renderFlat returns the entry as a line of a flat file, indented as given
+// (with any fields other than the host, path and url left out)
+func renderFlat(e Entry, indent string) (string, error) {
+	key := e.Path
+	if e.Host != "" {
+		key = e.Host + e.Path
+	}
+	return fmt.Sprintf("%s%s = %s\n", indent, quoteFlat(key, true),
+		quoteFlat(e.URL, false)), nil
+}
+
+// quoteFlat returns the key (or value) for a line of a flat file, double
+// quoted if ParseFlat would not read it back as it is otherwise
+func quoteFlat(s string, key bool) string {
+	if strings.HasPrefix(s, `"`) || strings.TrimSpace(s) != s ||
+		strings.ContainsAny(s, "\r\n") ||
+		(key && (strings.Contains(s, "=") || strings.HasPrefix(s, "#"))) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// yamlValueRegexp matches a line of a YAML entry setting some key, which
+// may start the entry
+var yamlValueRegexp = regexp.MustCompile(`^(\s*(?:-\s+)?)([a-z_]+):[ \t]*(.*?)([ \t]+#.*)?(\n?)$`)
+
+// setYAMLValue sets the given key of the YAML entry in the given lines to
+// the given (non-empty) value, returning false if it has no line of its own
+func setYAMLValue(lines []string, key, value string) bool {
+	if value == "" {
+		return false
+	}
+	yml, err := yaml.Marshal(value)
+	if err != nil {
+		return false
+	}
+	value = strings.TrimSuffix(string(yml), "\n")
+
+	for i, line := range lines {
+		m := yamlValueRegexp.FindStringSubmatch(line)
+		if m == nil || m[2] != key {
+			continue
+		}
+		lines[i] = m[1] + key + ": " + value + m[4] + m[5]
+		return true
+	}
+	return false
+}
+
+// addYAMLValue adds a line setting the given key to the given value after
+// the path line of the YAML entry in the given lines, returning them
+func addYAMLValue(lines []string, key, value string) ([]string, bool) {
+	if value == "" {
+		return lines, false
+	}
+	yml, err := yaml.Marshal(value)
+	if err != nil {
+		return lines, false
+	}
+	value = strings.TrimSuffix(string(yml), "\n")
+
+	for i, line := range lines {
+		m := yamlValueRegexp.FindStringSubmatch(line)
+		if m == nil || m[2] != "path" || m[5] == "" {
+			continue
+		}
+		added := strings.Repeat(" ", len(m[1])) + key + ": " + value + "\n"
+		return append(lines[:i+1], append([]string{added}, lines[i+1:]...)...), true
+	}
+	return lines, false
+}
+
+// writeJSONEntries writes the entries as a JSON list, as a JSONFileStore
+// does
+func writeJSONEntries(w io.Writer, entries []Entry) error {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
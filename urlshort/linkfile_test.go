@@ -0,0 +1,231 @@
+package urlshort
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// editLinkFile writes the given data to a file with the given name, opens
+// it as a LinkFile, applies the given edits and saves it, returning what
+// the file holds then
+func editLinkFile(t *testing.T, name, ext, data string, edits func(*LinkFile)) string {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(fileName, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenLinkFileAs(fileName, ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edits(f)
+	if err = f.Save(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(saved)
+}
+
+// mustEdit fails the test if the error of an edit is not nil
+func mustEdit(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLinkFileYAMLKeepsComments(t *testing.T) {
+	data := `# Team links
+# Edited by hand too
+
+# The docs
+- path: /docs
+  url: https://example.com/docs  # Moving soon
+
+# Old stuff
+- path: /old
+  url: https://example.com/old
+
+- path: /blog
+  url: https://example.com/blog
+  description: The blog
+`
+	got := editLinkFile(t, "links.yaml", ".yaml", data, func(f *LinkFile) {
+		mustEdit(t, f.Move("/docs", "/manual"))
+		mustEdit(t, f.Remove("/old"))
+		mustEdit(t, f.Add(Entry{Path: "/new", URL: "https://example.com/new", MaxUses: 5}))
+		if err := f.Add(Entry{Path: "/blog", URL: "https://example.com"}); err != ErrEntryExists {
+			t.Errorf("Add() of an existing path error = %v", err)
+		}
+		if err := f.Remove("/old"); err != ErrEntryNotFound {
+			t.Errorf("Remove() of a missing path error = %v", err)
+		}
+	})
+	want := `# Team links
+# Edited by hand too
+
+# The docs
+- path: /manual
+  url: https://example.com/docs  # Moving soon
+
+
+- path: /blog
+  url: https://example.com/blog
+  description: The blog
+- path: /new
+  url: https://example.com/new
+  max_uses: 5
+`
+	if got != want {
+		t.Errorf("saved file:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLinkFileYAMLHost(t *testing.T) {
+	// Moving to a host adds the key without touching the rest of the entry
+	data := "  - path: /a  # First\n    url: https://example.com/a\n"
+	got := editLinkFile(t, "links.yml", ".yml", data, func(f *LinkFile) {
+		mustEdit(t, f.Move("/a", "go.example.com/b"))
+		mustEdit(t, f.Add(Entry{Path: "/c", URL: "https://example.com/c"}))
+	})
+	want := "  - path: /b  # First\n    host: go.example.com\n    url: https://example.com/a\n" +
+		"  - path: /c\n    url: https://example.com/c\n"
+	if got != want {
+		t.Errorf("saved file:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLinkFileFlat(t *testing.T) {
+	data := `# Flat links
+/a = https://example.com/a?x=1
+
+# Going away
+/b = https://example.com/b
+"/c" = "https://example.com/c" # Quoted
+`
+	got := editLinkFile(t, "links.txt", ".txt", data, func(f *LinkFile) {
+		mustEdit(t, f.Remove("/b"))
+		mustEdit(t, f.Move("/a", "go.example.com/a"))
+		mustEdit(t, f.Add(Entry{Path: "/d=e", URL: "https://example.com/d"}))
+		mustEdit(t, f.Add(Entry{Path: "/f", URL: `"quoted"`}))
+		err := f.Add(Entry{Path: "/g", URL: "https://example.com/g", Status: 301})
+		if err == nil || !strings.Contains(err.Error(), "only paths and urls") {
+			t.Errorf("Add() with a status error = %v", err)
+		}
+	})
+	want := `# Flat links
+go.example.com/a = https://example.com/a?x=1
+
+"/c" = "https://example.com/c" # Quoted
+"/d=e" = https://example.com/d
+/f = "\"quoted\""
+`
+	if got != want {
+		t.Errorf("saved file:\n%s\nwant:\n%s", got, want)
+	}
+
+	entries, err := ParseFlat([]byte(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEntries := []Entry{
+		{Host: "go.example.com", Path: "/a", URL: "https://example.com/a?x=1"},
+		{Path: "/c", URL: "https://example.com/c"},
+		{Path: "/d=e", URL: "https://example.com/d"},
+		{Path: "/f", URL: `"quoted"`},
+	}
+	if !reflect.DeepEqual(entries, wantEntries) {
+		t.Errorf("saved entries = %+v, want %+v", entries, wantEntries)
+	}
+}
+
+func TestLinkFileYAMLGroupedRewrites(t *testing.T) {
+	data := `# Grouped by host
+go.example.com:
+  - path: /b
+    url: https://example.com/b
+"*":
+  - path: /a
+    url: https://example.com/a
+`
+	got := editLinkFile(t, "links.yaml", ".yaml", data, func(f *LinkFile) {
+		mustEdit(t, f.Add(Entry{Path: "/c", URL: "https://example.com/c"}))
+		mustEdit(t, f.Move("*/a", "*/z"))
+	})
+
+	// Written out in full, as a list, but in the same order
+	if strings.Contains(got, "#") || !strings.HasPrefix(got, "- ") {
+		t.Errorf("saved file is not a full rewrite:\n%s", got)
+	}
+	entries, err := ParseYAML([]byte(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Host: "go.example.com", Path: "/b", URL: "https://example.com/b"},
+		{Host: "*", Path: "/z", URL: "https://example.com/a"},
+		{Path: "/c", URL: "https://example.com/c"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("saved entries = %+v, want %+v", entries, want)
+	}
+}
+
+func TestLinkFileJSONStore(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "store.db")
+	store, err := NewJSONFileStore(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []Entry{
+		{Path: "/b", URL: "https://example.com/b", Uses: 2, MaxUses: 10},
+		{Path: "/a", URL: "https://example.com/a"},
+	} {
+		mustEdit(t, store.Put(e))
+	}
+
+	f, err := OpenLinkFileAs(fileName, ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := func(entries []Entry) []string {
+		var keys []string
+		for _, e := range entries {
+			keys = append(keys, e.Key())
+		}
+		return keys
+	}
+	if got := order(f.Entries()); !reflect.DeepEqual(got, []string{"/a", "/b"}) {
+		t.Fatalf("store file entries in order %v, want [/a /b]", got)
+	}
+	mustEdit(t, f.Move("/b", "/c"))
+	mustEdit(t, f.Add(Entry{Path: "/d", URL: "https://example.com/d"}))
+	mustEdit(t, f.Save())
+
+	// The store reads the edits back, keeping the fields it tracks
+	store, err = NewJSONFileStore(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := store.Lookup("/c"); !ok || e.Uses != 2 || e.MaxUses != 10 {
+		t.Errorf("moved entry = %+v, %v", e, ok)
+	}
+	if _, ok := store.Lookup("/d"); !ok {
+		t.Error("added entry missing")
+	}
+
+	f, err = OpenLinkFileAs(fileName, ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/a", "/c", "/d"}
+	if got := order(f.Entries()); !reflect.DeepEqual(got, want) {
+		t.Errorf("entries in order %v, want %v", got, want)
+	}
+}
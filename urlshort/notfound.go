@@ -0,0 +1,161 @@
+package urlshort
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Most suggestions shown on the not found page
+const maxSuggestions = 5
+
+const notFoundTmplText = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>{{.Path}} - not found</title>
+</head>
+<body>
+	<h1>Not found</h1>
+	<p>There is no short link <code>{{.Path}}</code>.</p>
+	{{if .Suggestions}}<p>Did you mean:</p>
+	<ul>
+	{{range .Suggestions}}	<li><a href="{{.}}">{{.}}</a></li>
+	{{end}}</ul>{{end}}
+</body>
+</html>`
+
+var notFoundTmpl = template.Must(template.New("notfound").Parse(notFoundTmplText))
+
+// notFoundPage holds what is shown on the not found page
+type notFoundPage struct {
+	Path        string
+	Suggestions []string
+}
+
+// NotFoundHandler returns an http.HandlerFunc responding 404 Not Found
+// with a page suggesting the short paths in the given stores closest to
+// the one requested, by edit distance and shared prefix. Only the paths
+// served on the request's host are suggested, and patterns are left out.
+// It is meant as the fallback handler of the others in this package, as in
+//
+//	store := NewMemoryStore(pathsToUrls)
+//	handler := StoreHandler(store, NotFoundHandler(store))
+func NotFoundHandler(stores ...Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := notFoundPage{
+			Path:        r.URL.Path,
+			Suggestions: suggestPaths(stores, r.Host, r.URL.Path),
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusNotFound)
+		if err := notFoundTmpl.Execute(w, page); err != nil {
+			log.Printf("urlshort: error writing not found page: %v", err)
+		}
+	})
+}
+
+// suggestPaths returns the paths of the entries in the stores served on the
+// host that are close enough to the given path, closest first
+func suggestPaths(stores []Store, host, path string) []string {
+	type suggestion struct {
+		path     string
+		distance int
+		prefix   int
+	}
+
+	host = normalizeHost(host)
+	want := strings.ToLower(path)
+	seen := make(map[string]bool)
+	var found []suggestion
+	for _, store := range stores {
+		for _, e := range store.List() {
+			if isPattern(e.Path) || seen[e.Path] {
+				continue
+			}
+			if h := normalizeHost(e.Host); h != "" && h != host {
+				continue
+			}
+			seen[e.Path] = true
+
+			have := strings.ToLower(e.Path)
+			s := suggestion{
+				path:     e.Path,
+				distance: editDistance(want, have),
+				prefix:   sharedPrefix(want, have),
+			}
+
+			// Close enough if a few edits away (more for longer paths) or
+			// sharing a good part of the path beyond its leading '/'
+			maxDistance := 2 + len(want)/5
+			if s.distance <= maxDistance || (s.prefix >= 4 && s.prefix*2 >= len(want)) {
+				found = append(found, s)
+			}
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if a.distance != b.distance {
+			return a.distance < b.distance
+		}
+		if a.prefix != b.prefix {
+			return a.prefix > b.prefix
+		}
+		return a.path < b.path
+	})
+	if len(found) > maxSuggestions {
+		found = found[:maxSuggestions]
+	}
+
+	paths := make([]string, len(found))
+	for i, s := range found {
+		paths[i] = s.path
+	}
+	return paths
+}
+
+// editDistance returns the Levenshtein distance between the given strings,
+// counting bytes
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// sharedPrefix returns the length of the common prefix of the given strings
+func sharedPrefix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
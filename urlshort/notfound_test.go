@@ -0,0 +1,86 @@
+package urlshort
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// suggestionRegexp matches a suggestion on the not found page
+var suggestionRegexp = regexp.MustCompile(`<li><a href="([^"]*)">`)
+
+func TestNotFoundHandler(t *testing.T) {
+	store := NewMemoryStoreFromEntries([]Entry{
+		{Path: "/docs", URL: "https://example.com/docs"},
+		{Path: "/dogs", URL: "https://example.com/dogs"},
+		{Path: "/documentation", URL: "https://example.com/documentation"},
+		{Path: "/other", URL: "https://example.com/other"},
+		{Path: "/doc/*", URL: "https://example.com/doc"},
+		{Host: "go.example.com", Path: "/dock", URL: "https://example.com/dock"},
+		{Host: "links.example.com", Path: "/doc2", URL: "https://example.com/doc2"},
+	})
+	file := NewMemoryStoreFromEntries([]Entry{
+		{Path: "/docs", URL: "https://example.com/docs-from-file"},
+		{Path: "/DOC", URL: "https://example.com/upper"},
+	})
+	handler := NotFoundHandler(store, file)
+
+	tests := []struct {
+		name   string
+		target string
+		want   []string
+	}{
+		{"ranked", "http://go.example.com/doc",
+			[]string{"/DOC", "/dock", "/docs", "/dogs", "/documentation"}},
+		{"other host", "http://links.example.com/doc",
+			[]string{"/DOC", "/doc2", "/docs", "/dogs", "/documentation"}},
+		{"no host entries", "http://example.com/doc",
+			[]string{"/DOC", "/docs", "/dogs", "/documentation"}},
+		{"shared prefix", "http://example.com/documents",
+			[]string{"/documentation"}},
+		{"nothing close", "http://example.com/zzzzzzzz", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != http.StatusNotFound {
+				t.Errorf("status %d, want %d", w.Code, http.StatusNotFound)
+			}
+			if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+				t.Errorf("Content-Type = %q", got)
+			}
+
+			var got []string
+			for _, m := range suggestionRegexp.FindAllStringSubmatch(w.Body.String(), -1) {
+				got = append(got, m[1])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("suggestions = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotFoundHandlerLimit(t *testing.T) {
+	var entries []Entry
+	for _, path := range []string{"/a1", "/a2", "/a3", "/a4", "/a5", "/a6", "/a"} {
+		entries = append(entries, Entry{Path: path, URL: "https://example.com"})
+	}
+	handler := NotFoundHandler(NewMemoryStoreFromEntries(entries))
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/a0", nil))
+	var got []string
+	for _, m := range suggestionRegexp.FindAllStringSubmatch(w.Body.String(), -1) {
+		got = append(got, m[1])
+	}
+	// All one edit away sharing "/a", so the first few by path
+	want := []string{"/a", "/a1", "/a2", "/a3", "/a4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("suggestions = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,183 @@
+package urlshort
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ParseYAML parses a list of entries in the format:
+//
+//   - path: /some-path
+//     url: https://www.some-url.com/demo
+//     expires_at: 2030-01-01T00:00:00Z   # Optional
+//     max_uses: 10                       # Optional
+//     status: 301                        # Optional (301/302/303/307/308)
+//     query: merge                       # Optional (drop/forward/merge)
+//     split: [{url: https://beta.some-url.com/demo, weight: 2}]
+//     fallbacks: [https://mirror.some-url.com/demo]
+//     description: Demo of the site      # Optional, as are the other
+//     interstitial: true                 # fields of Entry
+//
+// Entries can also be grouped by the host they are served on, with "*"
+// for those served on any host:
+//
+//	go.example.com:
+//	  - path: /some-path
+//	    url: https://www.some-url.com/demo
+//	"*":
+//	  - path: /other-path
+//	    url: https://www.some-url.com/other
+//
+// Keys other than those of an Entry are reported as errors.
+func ParseYAML(yml []byte) ([]Entry, error) {
+	var top interface{}
+	err := yaml.Unmarshal(yml, &top)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := top.(map[interface{}]interface{}); ok {
+		return parseYAMLHosts(yml)
+	}
+
+	var entries []Entry
+	err = yaml.UnmarshalStrict(yml, &entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// WriteYAML writes the entries as a list in the format read by ParseYAML.
+func WriteYAML(w io.Writer, entries []Entry) error {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	yml, err := yaml.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(yml)
+	return err
+}
+
+// parseYAMLHosts parses YAML with entries grouped by host, returning them
+// in the order they appear with their Host set
+func parseYAMLHosts(yml []byte) ([]Entry, error) {
+	var groups map[string][]Entry
+	err := yaml.UnmarshalStrict(yml, &groups)
+	if err != nil {
+		return nil, err
+	}
+
+	// The map loses the order of the hosts, so get that separately
+	var order yaml.MapSlice
+	err = yaml.Unmarshal(yml, &order)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, item := range order {
+		host := fmt.Sprint(item.Key)
+		for _, e := range groups[host] {
+			if e.Host != "" && normalizeHost(e.Host) != normalizeHost(host) {
+				return nil, fmt.Errorf("entry %s has host %s but is under host %s",
+					e.Path, e.Host, host)
+			}
+			e.Host = host
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// ParseJSON parses a list of entries in the format:
+//
+//	[{"path": "/some-path", "url": "https://www.some-url.com/demo"}]
+//
+// Keys other than those of an Entry are reported as errors.
+func ParseJSON(data []byte) ([]Entry, error) {
+	var entries []Entry
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ParseFlat parses entries from a flat list of lines in the format:
+//
+//	# Comment
+//	/some-path = https://www.some-url.com/demo
+//
+// The path may be preceded by a host, as in go.example.com/some-path, for
+// an entry served only on that host. Either side of the '=' may be double
+// quoted, so a TOML document with a single table of strings is accepted
+// too: a quoted path may contain '=', and a quoted URL may be followed by
+// a comment. Unquoted URLs are taken up to the end of the line, '#' and
+// all. Lines that are neither blank, comments nor key/value pairs are
+// reported as errors.
+func ParseFlat(data []byte) ([]Entry, error) {
+	var entries []Entry
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		path, rest, err := unquoteFlat(line, true)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		if !strings.HasPrefix(rest, "=") {
+			return nil, fmt.Errorf("line %d: expected 'path = url'", lineNum)
+		}
+		url, rest, err := unquoteFlat(rest[1:], false)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		if rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("line %d: unexpected '%s' after url", lineNum, rest)
+		}
+
+		e := Entry{URL: url}
+		e.Host, e.Path = SplitKey(path)
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// unquoteFlat returns the key (or value) at the start of the given part of
+// a flat entry line, trimmed and without its surrounding double quotes (if
+// any), along with the trimmed rest of the line. An unquoted key ends at
+// the first '=', and an unquoted value at the end of the line
+func unquoteFlat(s string, key bool) (string, string, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, `"`) {
+		quoted, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return "", "", fmt.Errorf("bad quoted string %s", s)
+		}
+		unquoted, err := strconv.Unquote(quoted)
+		return unquoted, strings.TrimSpace(s[len(quoted):]), err
+	}
+	if i := strings.Index(s, "="); key && i >= 0 {
+		return strings.TrimSpace(s[:i]), s[i:], nil
+	}
+	return s, "", nil
+}
@@ -0,0 +1,120 @@
+package urlshort
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func([]byte) ([]Entry, error)
+		data  string
+		key   string
+	}{
+		{"yaml", ParseYAML, "- path: /a\n  uri: https://example.com\n", "uri"},
+		{"yaml by host", ParseYAML,
+			"go.example.com:\n  - path: /a\n    uri: https://example.com\n", "uri"},
+		{"yaml misspelt option", ParseYAML,
+			"- path: /a\n  url: https://example.com\n  max_use: 3\n", "max_use"},
+		{"json", ParseJSON, `[{"path": "/a", "uri": "https://example.com"}]`, "uri"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := tt.parse([]byte(tt.data))
+			if err == nil {
+				t.Fatalf("parsed %+v, want an error for the unknown key", entries)
+			}
+			if !strings.Contains(err.Error(), tt.key) {
+				t.Errorf("error %q does not name the key", err)
+			}
+		})
+	}
+}
+
+func TestParseYAMLHosts(t *testing.T) {
+	yml := `
+links.example.com:
+  - path: /b
+    url: https://example.com/b
+"*":
+  - path: /a
+    url: https://example.com/a
+go.example.com:
+  - path: /c
+    url: https://example.com/c
+    host: GO.example.com
+`
+	got, err := ParseYAML([]byte(yml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Host: "links.example.com", Path: "/b", URL: "https://example.com/b"},
+		{Host: "*", Path: "/a", URL: "https://example.com/a"},
+		{Host: "go.example.com", Path: "/c", URL: "https://example.com/c"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseYAML() = %+v, want %+v", got, want)
+	}
+
+	mismatched := "go.example.com:\n  - path: /a\n    url: https://example.com\n" +
+		"    host: links.example.com\n"
+	if _, err := ParseYAML([]byte(mismatched)); err == nil {
+		t.Error("ParseYAML() accepted an entry under another host")
+	}
+}
+
+func TestParseFlat(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []Entry
+		wantErr string
+	}{
+		{"plain", "# Links\n\n/a = https://example.com/a\n  /b=https://example.com/b  \n",
+			[]Entry{
+				{Path: "/a", URL: "https://example.com/a"},
+				{Path: "/b", URL: "https://example.com/b"},
+			}, ""},
+		{"host", "go.example.com/a = https://example.com/a\n",
+			[]Entry{{Host: "go.example.com", Path: "/a", URL: "https://example.com/a"}}, ""},
+		{"unquoted url keeps = and #", "/a = https://example.com/a?x=1#top\n",
+			[]Entry{{Path: "/a", URL: "https://example.com/a?x=1#top"}}, ""},
+		{"toml", "\"/a\" = \"https://example.com/a\"\n\"/b\" = \"https://example.com/b\" # Comment\n",
+			[]Entry{
+				{Path: "/a", URL: "https://example.com/a"},
+				{Path: "/b", URL: "https://example.com/b"},
+			}, ""},
+		{"quoted path with =", "\"/a=b\" = https://example.com/a\n",
+			[]Entry{{Path: "/a=b", URL: "https://example.com/a"}}, ""},
+		{"escapes", "\"/a\\u00e9\" = \"https://example.com/\\\"q\\\"\"\n",
+			[]Entry{{Path: "/aé", URL: `https://example.com/"q"`}}, ""},
+		{"no =", "/a = https://example.com/a\n/b https://example.com/b\n", nil,
+			"line 2: expected 'path = url'"},
+		{"no = after quoted path", "\"/a\" https://example.com/a\n", nil,
+			"line 1: expected 'path = url'"},
+		{"unterminated quote", "/a = \"https://example.com/a\n", nil,
+			"line 1: bad quoted string \"https://example.com/a"},
+		{"text after quoted url", "/a = \"https://example.com/a\" extra\n", nil,
+			"line 1: unexpected 'extra' after url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFlat([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("ParseFlat() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFlat() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package urlshort
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Suffix of a short path, or query parameter, asking for a preview of the
+// destination instead of a redirect
+const (
+	previewSuffix = "+"
+	previewParam  = "preview"
+)
+
+const previewTmplText = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="referrer" content="no-referrer">
+	<title>{{.Path}} - link preview</title>
+</head>
+<body>
+	<h1>{{.Path}}</h1>
+	<p>This short link goes to:</p>
+	<p><code>{{.Dest}}</code></p>
+	{{if .Description}}<p>{{.Description}}</p>{{end}}
+	{{if .Created}}<p>Created {{.Created.Format "2 Jan 2006"}}</p>{{end}}
+	<p><a href="{{.Dest}}" style="display: inline-block; padding: 0.5em 1em;
+		border: 1px solid; border-radius: 4px; text-decoration: none">
+		Continue to {{.Host}}</a></p>
+</body>
+</html>`
+
+var previewTmpl = template.Must(template.New("preview").Parse(previewTmplText))
+
+// previewPage holds what is shown on the preview page of an entry
+type previewPage struct {
+	Path        string
+	Dest        string
+	Host        string // Of the destination
+	Description string
+	Created     *time.Time
+}
+
+// previewRequested returns whether the request asks for a preview, along
+// with the short path to preview (the request path without any suffix)
+func previewRequested(r *http.Request) (string, bool) {
+	path := r.URL.Path
+	if len(path) > 1 && strings.HasSuffix(path, previewSuffix) {
+		return strings.TrimSuffix(path, previewSuffix), true
+	}
+	return path, r.URL.Query().Get(previewParam) == "1"
+}
+
+// resolveRequest resolves the request as resolve does, returning the entry,
+// the URL to redirect to and the short path resolved, along with whether
+// the request asks for a preview. A path with the preview suffix is
+// resolved without it before being matched against patterns, so that
+// patterns such as /docs/* do not take the suffix as part of the path,
+// unless an entry has the suffix in its exact path.
+func resolveRequest(store Store, r *http.Request) (Entry, string, string, bool, bool) {
+	path := r.URL.Path
+	previewPath, asked := previewRequested(r)
+	switch {
+	case !asked:
+	case previewPath == path: // Asked for with the query
+		e, dest, ok := resolve(store, r.Host, path)
+		return e, dest, path, ok, ok
+	default:
+		if e, ok := lookupExact(store, r.Host, path); ok {
+			return e, e.URL, path, false, true
+		}
+		if e, dest, ok := resolve(store, r.Host, previewPath); ok {
+			return e, dest, previewPath, true, true
+		}
+	}
+	e, dest, ok := resolve(store, r.Host, path)
+	return e, dest, path, false, ok
+}
+
+// destQuery returns the query string of the request to hand on to the
+// destination, without the parameter asking for a preview
+func destQuery(r *http.Request) string {
+	q := r.URL.Query()
+	if q.Get(previewParam) != "1" {
+		return r.URL.RawQuery
+	}
+	q.Del(previewParam)
+	return q.Encode()
+}
+
+// servePreview writes the preview page of the entry, which redirects to
+// the given destination
+func servePreview(w http.ResponseWriter, r *http.Request, e Entry, dest string) {
+	page := previewPage{
+		Path:        e.Path,
+		Dest:        dest,
+		Host:        dest,
+		Description: e.Description,
+		Created:     e.Created,
+	}
+	if i := strings.Index(dest, "://"); i >= 0 {
+		page.Host = strings.SplitN(dest[i+3:], "/", 2)[0]
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := previewTmpl.Execute(w, page); err != nil {
+		log.Printf("urlshort: error writing preview of %s: %v", e.Path, err)
+	}
+}
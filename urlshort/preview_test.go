@@ -0,0 +1,77 @@
+package urlshort
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPreviewAppliesQuery(t *testing.T) {
+	store := NewMemoryStoreFromEntries([]Entry{
+		{Path: "/i", URL: "https://example.com/i?a=1", Query: QueryMerge, Interstitial: true},
+		{Path: "/p", URL: "https://example.com/p", Query: QueryForward},
+	})
+	handler := StoreHandler(store, http.NotFoundHandler())
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/i?b=2", "https://example.com/i?a=1&amp;b=2"},
+		{"/p+?b=2", "https://example.com/p?b=2"},
+		{"/p?b=2&preview=1", "https://example.com/p?b=2"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, want %d", tt.target, w.Code, http.StatusOK)
+		}
+		if body := w.Body.String(); !strings.Contains(body, `href="`+tt.want+`"`) {
+			t.Errorf("%s: preview does not link to %s:\n%s", tt.target, tt.want, body)
+		}
+	}
+}
+
+func TestPreviewSuffixWithPatterns(t *testing.T) {
+	store := NewMemoryStoreFromEntries([]Entry{
+		{Path: "/docs/*", URL: "https://docs.example.com"},
+		{Path: "/go/{name}", URL: "https://pkg.example.com/{name}"},
+		{Path: "/c++", URL: "https://example.com/cpp"},
+		{Path: "/plain", URL: "https://example.com/plain"},
+	})
+	handler := StoreHandler(store, http.NotFoundHandler())
+
+	tests := []struct {
+		target   string
+		status   int
+		location string // Of a redirect, else what a preview links to
+	}{
+		{"/docs/guide/intro+", http.StatusOK, "https://docs.example.com/guide/intro"},
+		{"/docs/guide/intro", http.StatusSeeOther, "https://docs.example.com/guide/intro"},
+		{"/go/yaml+", http.StatusOK, "https://pkg.example.com/yaml"},
+		{"/c++", http.StatusSeeOther, "https://example.com/cpp"},
+		{"/c+++", http.StatusOK, "https://example.com/cpp"},
+		{"/plain+", http.StatusOK, "https://example.com/plain"},
+		{"/missing+", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, w.Code, tt.status)
+			continue
+		}
+		switch tt.status {
+		case http.StatusSeeOther:
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("%s: redirected to %s, want %s", tt.target, got, tt.location)
+			}
+		case http.StatusOK:
+			if body := w.Body.String(); !strings.Contains(body, `href="`+tt.location+`"`) {
+				t.Errorf("%s: preview does not link to %s:\n%s", tt.target, tt.location, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package urlshort
+
+import (
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Limits of the size (in pixels) of QR code images, and the quiet zone
+// around them (in modules) required by the standard
+const (
+	defaultQRPixels = 256
+	minQRPixels     = 32
+	maxQRPixels     = 2048
+	qrBorder        = 4
+)
+
+// QRHandler returns an http.Handler serving QR codes of the short URLs of
+// the entries in the given store, for request paths like /some-path.png or
+// /some-path.svg. Mount it with its prefix stripped, for example:
+//
+//	mux.Handle("/qr/", http.StripPrefix("/qr", QRHandler(store, "")))
+//
+// The size query parameter sets the image size in pixels (default 256, and
+// no less than a pixel per module of the code, border included) and
+// ec the error correction level: L, M (the default), Q or H. Short URLs are
+// formed from baseURL, or from the request's own host if it is empty.
+func QRHandler(store Store, baseURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, format := r.URL.Path, ""
+		for _, ext := range []string{".png", ".svg"} {
+			if strings.HasSuffix(path, ext) {
+				path, format = strings.TrimSuffix(path, ext), ext
+			}
+		}
+		if format == "" {
+			http.Error(w, "QR code path must end with .png or .svg",
+				http.StatusNotFound)
+			return
+		}
+		if _, _, ok := resolve(store, r.Host, path); !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		pixels := defaultQRPixels
+		if s := r.URL.Query().Get("size"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < minQRPixels || n > maxQRPixels {
+				http.Error(w, "size must be a number of pixels from "+
+					strconv.Itoa(minQRPixels)+" to "+strconv.Itoa(maxQRPixels),
+					http.StatusBadRequest)
+				return
+			}
+			pixels = n
+		}
+
+		level := QRMedium
+		switch strings.ToUpper(r.URL.Query().Get("ec")) {
+		case "", "M":
+		case "L":
+			level = QRLow
+		case "Q":
+			level = QRQuartile
+		case "H":
+			level = QRHigh
+		default:
+			http.Error(w, "ec must be one of L, M, Q or H", http.StatusBadRequest)
+			return
+		}
+
+		base := baseURL
+		if base == "" {
+			base = requestBaseURL(r)
+		}
+		code, err := EncodeQR([]byte(strings.TrimSuffix(base, "/")+path), level)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if min := code.MinPixels(qrBorder); pixels < min {
+			http.Error(w, "size must be at least "+strconv.Itoa(min)+
+				" pixels for this code", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		if format == ".svg" {
+			w.Header().Set("Content-Type", "image/svg+xml")
+			err = code.WriteSVG(w, pixels, qrBorder)
+		} else {
+			w.Header().Set("Content-Type", "image/png")
+			err = png.Encode(w, code.SizedImage(pixels, qrBorder))
+		}
+		if err != nil {
+			log.Printf("urlshort: error writing QR code of %s: %v", path, err)
+		}
+	})
+}
@@ -0,0 +1,557 @@
+package urlshort
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// QR code encoder supporting byte mode data in versions 1 to 40, as
+// described in ISO/IEC 18004.
+
+// QRLevel is the error correction level of a QR code.
+type QRLevel int
+
+// Error correction levels, recovering about 7%, 15%, 25% and 30% of the
+// code respectively
+const (
+	QRLow QRLevel = iota
+	QRMedium
+	QRQuartile
+	QRHigh
+)
+
+// ErrQRTooLong is returned when data does not fit in any QR code version.
+var ErrQRTooLong = errors.New("urlshort: data too long for a QR code")
+
+// Error correction codewords per block, by level and version
+var qrECCPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// Error correction blocks, by level and version
+var qrNumBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// Bits identifying each level in the format information
+var qrLevelBits = [4]uint{1, 0, 3, 2}
+
+// QRCode is an encoded QR code: a square grid of dark and light modules.
+type QRCode struct {
+	Size    int // Modules per side
+	modules [][]bool
+	isFunc  [][]bool // Modules of function patterns, not data
+}
+
+// Dark returns whether the module at column x, row y is dark. Modules
+// outside the code are light.
+func (q *QRCode) Dark(x, y int) bool {
+	return x >= 0 && x < q.Size && y >= 0 && y < q.Size && q.modules[y][x]
+}
+
+// EncodeQR encodes the data in byte mode in the smallest QR code version
+// that holds it at the given error correction level.
+func EncodeQR(data []byte, level QRLevel) (*QRCode, error) {
+	if level < QRLow || level > QRHigh {
+		return nil, fmt.Errorf("urlshort: unknown QR level %d", level)
+	}
+
+	version := 0
+	for v := 1; v <= 40; v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if len(data) < 1<<uint(countBits) &&
+			4+countBits+8*len(data) <= qrDataCodewords(v, level)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrQRTooLong
+	}
+
+	// Build the bit stream: mode, count, data, terminator and padding
+	var bits qrBits
+	bits.append(0x4, 4) // Byte mode
+	if version < 10 {
+		bits.append(uint(len(data)), 8)
+	} else {
+		bits.append(uint(len(data)), 16)
+	}
+	for _, b := range data {
+		bits.append(uint(b), 8)
+	}
+
+	capacity := qrDataCodewords(version, level) * 8
+	terminator := capacity - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := uint(0xEC); len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i>>3] |= 1 << uint(7-i&7)
+		}
+	}
+
+	q := newQRCode(version)
+	q.drawFunctionPatterns(version, level)
+	q.drawCodewords(qrAddECC(codewords, version, level))
+
+	// Use the mask giving the lowest penalty
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(level, mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		q.applyMask(mask) // Undo, as masking is an XOR
+	}
+	q.applyMask(best)
+	q.drawFormatBits(level, best)
+
+	return q, nil
+}
+
+// MinPixels returns the smallest size in pixels of an image of the code
+// with a border of the given number of modules, one pixel per module.
+func (q *QRCode) MinPixels(border int) int {
+	return q.Size + 2*border
+}
+
+// SizedImage returns the code as an image of the given width and height in
+// pixels, with a light border (quiet zone) of the given number of modules.
+// Unless the size is a multiple of the modules per side, modules differ in
+// width by a pixel. Sizes smaller than the modules per side (see MinPixels)
+// are raised to it, as some modules would be left out.
+func (q *QRCode) SizedImage(pixels, border int) image.Image {
+	side := q.MinPixels(border)
+	if pixels < side {
+		pixels = side
+	}
+	img := image.NewPaletted(image.Rect(0, 0, pixels, pixels),
+		color.Palette{color.White, color.Black})
+
+	for py := 0; py < pixels; py++ {
+		for px := 0; px < pixels; px++ {
+			if q.Dark(px*side/pixels-border, py*side/pixels-border) {
+				img.SetColorIndex(px, py, 1)
+			}
+		}
+	}
+	return img
+}
+
+// WriteSVG writes the code as an SVG image of the given width and height
+// in pixels, with a light border (quiet zone) of the given number of
+// modules.
+func (q *QRCode) WriteSVG(w io.Writer, pixels, border int) error {
+	side := q.Size + 2*border
+	_, err := fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">
+<rect width="100%%" height="100%%" fill="#FFFFFF"/>
+<path fill="#000000" d="`, pixels, pixels, side, side)
+	if err != nil {
+		return err
+	}
+
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if q.modules[y][x] {
+				if _, err = fmt.Fprintf(w, "M%d,%dh1v1h-1z", x+border, y+border); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	_, err = io.WriteString(w, "\"/>\n</svg>\n")
+	return err
+}
+
+// qrBits is a sequence of bits being built up
+type qrBits []bool
+
+// append adds the given number of low bits of val, most significant first
+func (b *qrBits) append(val uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, (val>>uint(i))&1 != 0)
+	}
+}
+
+// qrRawModules returns the number of modules of a version available for
+// data and error correction, after function patterns and format and version
+// information
+func qrRawModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+// qrDataCodewords returns the number of data codewords in a version at a
+// level
+func qrDataCodewords(version int, level QRLevel) int {
+	return qrRawModules(version)/8 -
+		qrECCPerBlock[level][version]*qrNumBlocks[level][version]
+}
+
+// qrAlignmentPositions returns the positions (both row and column) of the
+// centers of the alignment patterns of a version
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+
+	result := make([]int, numAlign)
+	result[0] = 6
+	for i, pos := numAlign-1, version*4+17-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+func newQRCode(version int) *QRCode {
+	size := version*4 + 17
+	q := &QRCode{
+		Size:    size,
+		modules: make([][]bool, size),
+		isFunc:  make([][]bool, size),
+	}
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.isFunc[i] = make([]bool, size)
+	}
+	return q
+}
+
+// setFunc sets the module at column x, row y as part of a function pattern
+func (q *QRCode) setFunc(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.isFunc[y][x] = true
+}
+
+// drawFunctionPatterns draws the timing, finder and alignment patterns and
+// the version information, and reserves the format information modules
+func (q *QRCode) drawFunctionPatterns(version int, level QRLevel) {
+	for i := 0; i < q.Size; i++ {
+		q.setFunc(6, i, i%2 == 0)
+		q.setFunc(i, 6, i%2 == 0)
+	}
+
+	for _, c := range [][2]int{{3, 3}, {q.Size - 4, 3}, {3, q.Size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || x >= q.Size || y < 0 || y >= q.Size {
+					continue
+				}
+				dist := qrMax(qrAbs(dx), qrAbs(dy))
+				q.setFunc(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	pos := qrAlignmentPositions(version)
+	last := len(pos) - 1
+	for i := range pos {
+		for j := range pos {
+			// Skip the corners taken by finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.setFunc(pos[i]+dx, pos[j]+dy, qrMax(qrAbs(dx), qrAbs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	q.drawFormatBits(level, 0) // Placeholder, redrawn once masked
+
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := (bits>>uint(i))&1 != 0
+			a, b := q.Size-11+i%3, i/3
+			q.setFunc(a, b, dark)
+			q.setFunc(b, a, dark)
+		}
+	}
+}
+
+// drawFormatBits draws both copies of the format information for the level
+// and mask, along with the dark module
+func (q *QRCode) drawFormatBits(level QRLevel, mask int) {
+	data := qrLevelBits[level]<<3 | uint(mask)
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>uint(i))&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		q.setFunc(8, i, bit(i))
+	}
+	q.setFunc(8, 7, bit(6))
+	q.setFunc(8, 8, bit(7))
+	q.setFunc(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunc(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.setFunc(q.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunc(8, q.Size-15+i, bit(i))
+	}
+	q.setFunc(8, q.Size-8, true)
+}
+
+// drawCodewords fills the data modules with the codewords, in the zigzag
+// order of columns pairs running up and down from the bottom right
+func (q *QRCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // Skip the vertical timing pattern
+		}
+		for vert := 0; vert < q.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.Size - 1 - vert // Upward column pair
+				}
+				if !q.isFunc[y][x] && i < len(data)*8 {
+					q.modules[y][x] = (data[i>>3]>>uint(7-i&7))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules picked by the given mask pattern
+func (q *QRCode) applyMask(mask int) {
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !q.isFunc[y][x] {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code would be to scan, by the rules used to
+// pick the mask
+func (q *QRCode) penalty() int {
+	const n1, n2, n3, n4 = 3, 3, 40, 10
+	result := 0
+	at := func(x, y int, byCol bool) bool {
+		if byCol {
+			return q.modules[x][y]
+		}
+		return q.modules[y][x]
+	}
+
+	// Runs of five or more same colored modules, and finder-like patterns,
+	// in rows then columns
+	finderA := []bool{true, false, true, true, true, false, true, false, false, false, false}
+	finderB := []bool{false, false, false, false, true, false, true, true, true, false, true}
+	for _, byCol := range []bool{false, true} {
+		for y := 0; y < q.Size; y++ {
+			run := 1
+			for x := 1; x <= q.Size; x++ {
+				if x < q.Size && at(x, y, byCol) == at(x-1, y, byCol) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					result += n1 + run - 5
+				}
+				run = 1
+			}
+
+			for x := 0; x+len(finderA) <= q.Size; x++ {
+				matchA, matchB := true, true
+				for k := range finderA {
+					v := at(x+k, y, byCol)
+					matchA = matchA && v == finderA[k]
+					matchB = matchB && v == finderB[k]
+				}
+				if matchA || matchB {
+					result += n3
+				}
+			}
+		}
+	}
+
+	// Two by two blocks of the same color
+	dark := 0
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				c := q.modules[y][x]
+				if c == q.modules[y][x-1] && c == q.modules[y-1][x] &&
+					c == q.modules[y-1][x-1] {
+					result += n2
+				}
+			}
+		}
+	}
+
+	// Balance of dark and light modules
+	total := q.Size * q.Size
+	k := (qrAbs(dark*20-total*10)+total-1)/total - 1
+	result += k * n4
+
+	return result
+}
+
+// qrAddECC splits the data codewords into blocks, adds the Reed-Solomon
+// error correction codewords to each, and interleaves the blocks
+func qrAddECC(data []byte, version int, level QRLevel) []byte {
+	numBlocks := qrNumBlocks[level][version]
+	eccLen := qrECCPerBlock[level][version]
+	rawCodewords := qrRawModules(version) / 8
+	numShort := numBlocks - rawCodewords%numBlocks
+	shortLen := rawCodewords / numBlocks
+
+	divisor := qrRSDivisor(eccLen)
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		n := shortLen - eccLen
+		if i >= numShort {
+			n++
+		}
+		dat := append([]byte(nil), data[k:k+n]...)
+		k += n
+		ecc := qrRSRemainder(dat, divisor)
+		if i < numShort {
+			dat = append(dat, 0) // Placeholder, skipped when interleaving
+		}
+		blocks[i] = append(dat, ecc...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortLen-eccLen || j >= numShort {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// qrRSDivisor returns the Reed-Solomon generator polynomial of the given
+// degree, highest power first with the leading 1 left out
+func qrRSDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = qrGFMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = qrGFMul(root, 0x02)
+	}
+	return result
+}
+
+// qrRSRemainder returns the Reed-Solomon error correction codewords for the
+// data, using the given divisor
+func qrRSRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= qrGFMul(d, factor)
+		}
+	}
+	return result
+}
+
+// qrGFMul multiplies two elements of GF(2^8) modulo x^8+x^4+x^3+x^2+1
+func qrGFMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+func qrAbs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func qrMax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,230 @@
+package urlshort
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEncodeQRVersion(t *testing.T) {
+	// Byte mode capacities of the smallest and largest versions
+	tests := []struct {
+		length int
+		level  QRLevel
+		size   int
+	}{
+		{1, QRLow, 21},
+		{17, QRLow, 21},
+		{18, QRLow, 25},
+		{14, QRMedium, 21},
+		{15, QRMedium, 25},
+		{11, QRQuartile, 21},
+		{7, QRHigh, 21},
+		{8, QRHigh, 25},
+		{2953, QRLow, 177},
+		{1273, QRHigh, 177},
+	}
+
+	for _, tt := range tests {
+		code, err := EncodeQR(bytes.Repeat([]byte("a"), tt.length), tt.level)
+		if err != nil {
+			t.Errorf("%d bytes at level %d: %v", tt.length, tt.level, err)
+			continue
+		}
+		if code.Size != tt.size {
+			t.Errorf("%d bytes at level %d: size %d, want %d",
+				tt.length, tt.level, code.Size, tt.size)
+		}
+	}
+}
+
+func TestEncodeQRErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		level QRLevel
+	}{
+		{"too long at L", bytes.Repeat([]byte("a"), 2954), QRLow},
+		{"too long at H", bytes.Repeat([]byte("a"), 1274), QRHigh},
+		{"bad level", []byte("a"), QRHigh + 1},
+	}
+
+	for _, tt := range tests {
+		if _, err := EncodeQR(tt.data, tt.level); err == nil {
+			t.Errorf("%s: EncodeQR() succeeded", tt.name)
+		}
+	}
+}
+
+func TestEncodeQRFunctionPatterns(t *testing.T) {
+	for _, level := range []QRLevel{QRLow, QRMedium, QRQuartile, QRHigh} {
+		code, err := EncodeQR([]byte("https://example.com/some-path"), level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := code.Size
+
+		// Finder patterns: dark ring, light ring, dark 3x3 center
+		for _, corner := range [][2]int{{0, 0}, {n - 7, 0}, {0, n - 7}} {
+			for dy := 0; dy < 7; dy++ {
+				for dx := 0; dx < 7; dx++ {
+					ring := qrMax(qrAbs(dx-3), qrAbs(dy-3))
+					if want := ring != 2; code.Dark(corner[0]+dx, corner[1]+dy) != want {
+						t.Fatalf("level %d: finder module (%d, %d) dark = %v",
+							level, corner[0]+dx, corner[1]+dy, !want)
+					}
+				}
+			}
+		}
+
+		// Timing patterns alternate, starting dark
+		for i := 8; i < n-8; i++ {
+			if code.Dark(i, 6) != (i%2 == 0) || code.Dark(6, i) != (i%2 == 0) {
+				t.Fatalf("level %d: timing module %d is wrong", level, i)
+			}
+		}
+
+		// The format information around the top left finder pattern
+		// names the level and is a valid BCH code word
+		var format uint
+		for i := 0; i <= 5; i++ {
+			format |= qrBit(code.Dark(8, i)) << uint(i)
+		}
+		format |= qrBit(code.Dark(8, 7))<<6 | qrBit(code.Dark(8, 8))<<7 |
+			qrBit(code.Dark(7, 8))<<8
+		for i := 9; i < 15; i++ {
+			format |= qrBit(code.Dark(14-i, 8)) << uint(i)
+		}
+		format ^= 0x5412
+		if got := format >> 13; got != qrLevelBits[level] {
+			t.Errorf("level %d: format level bits %b, want %b",
+				level, got, qrLevelBits[level])
+		}
+		rem := format
+		for i := 14; i >= 10; i-- {
+			if rem&(1<<uint(i)) != 0 {
+				rem ^= 0x537 << uint(i-10)
+			}
+		}
+		if rem != 0 {
+			t.Errorf("level %d: format information %015b is not a BCH code word",
+				level, format)
+		}
+	}
+}
+
+// qrBit returns 1 for a dark module and 0 for a light one
+func qrBit(dark bool) uint {
+	if dark {
+		return 1
+	}
+	return 0
+}
+
+func TestQRReedSolomon(t *testing.T) {
+	// The 1-M example of ISO/IEC 18004 Annex I, encoding "01234567"
+	data := []byte{0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11,
+		0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11}
+	want := []byte{0xA5, 0x24, 0xD4, 0xC1, 0xED, 0x36, 0xC7, 0x87, 0x2C, 0x55}
+
+	got := qrRSRemainder(data, qrRSDivisor(len(want)))
+	if !bytes.Equal(got, want) {
+		t.Errorf("qrRSRemainder() = % X, want % X", got, want)
+	}
+}
+
+func TestQRWriteSVG(t *testing.T) {
+	code, err := EncodeQR([]byte("https://example.com"), QRMedium)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = code.WriteSVG(&buf, 300, qrBorder); err != nil {
+		t.Fatal(err)
+	}
+	svg := buf.String()
+	for _, want := range []string{`width="300" height="300"`, `viewBox="0 0 33 33"`} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("SVG lacks %s:\n%s", want, svg)
+		}
+	}
+}
+
+func TestQRHandlerSize(t *testing.T) {
+	store := NewMemoryStoreFromEntries([]Entry{{Path: "/a", URL: "https://example.com"}})
+	handler := QRHandler(store, "https://go.example.com")
+
+	// https://go.example.com/a is a 25 module code at levels L and M, 33
+	// with its border
+	tests := []struct {
+		query  string
+		status int
+		size   int
+	}{
+		{"size=33", http.StatusOK, 33},
+		{"size=37", http.StatusOK, 37},
+		{"size=256", http.StatusOK, 256},
+		{"size=300", http.StatusOK, 300},
+		{"size=2048", http.StatusOK, 2048},
+		{"", http.StatusOK, defaultQRPixels},
+		{"size=32", http.StatusBadRequest, 0},
+		{"size=32&ec=L", http.StatusBadRequest, 0},
+		{"size=16", http.StatusBadRequest, 0},
+		{"size=4096", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a.png?"+tt.query, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.query, w.Code, tt.status, w.Body)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if b := img.Bounds(); b.Dx() != tt.size || b.Dy() != tt.size {
+			t.Errorf("%s: got a %dx%d image, want %d", tt.query, b.Dx(), b.Dy(), tt.size)
+		}
+	}
+}
+
+func TestQRSizedImageSamplesEveryModule(t *testing.T) {
+	code, err := EncodeQR([]byte("https://go.example.com/abcdefghij"), QRMedium)
+	if err != nil {
+		t.Fatal(err)
+	}
+	side := code.MinPixels(qrBorder)
+
+	for _, pixels := range []int{side - 5, side, side + 7, 3 * side} {
+		img := code.SizedImage(pixels, qrBorder)
+		n := img.Bounds().Dx()
+		if n < side {
+			t.Fatalf("%d pixels: got a %d pixel image, smaller than %d modules",
+				pixels, n, side)
+		}
+
+		// Every module has a pixel of its own color
+		seen := make(map[[2]int]bool)
+		for py := 0; py < n; py++ {
+			for px := 0; px < n; px++ {
+				x, y := px*side/n-qrBorder, py*side/n-qrBorder
+				seen[[2]int{x, y}] = true
+				r, _, _, _ := img.At(px, py).RGBA()
+				if dark := r == 0; dark != code.Dark(x, y) {
+					t.Fatalf("%d pixels: pixel (%d, %d) dark = %v", pixels, px, py, dark)
+				}
+			}
+		}
+		if len(seen) != side*side {
+			t.Errorf("%d pixels: %d of %d modules drawn", pixels, len(seen), side*side)
+		}
+	}
+}
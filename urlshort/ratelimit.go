@@ -0,0 +1,185 @@
+package urlshort
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults bounding the memory used for client buckets
+const (
+	defaultIdleTimeout = 10 * time.Minute
+	defaultMaxClients  = 100000
+)
+
+// RateLimit is a token bucket limit: Rate requests per second on average,
+// with bursts of up to Burst requests. A zero Rate means no limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimiter limits the rate of requests from each client, as identified
+// by IP address. Its fields must be set before Handler is first called.
+type RateLimiter struct {
+	// Limit applied to every path that has no limit of its own
+	Default RateLimit
+
+	// Limits for particular paths, each counted separately from the
+	// default limit
+	PerPath map[string]RateLimit
+
+	// Number of proxies in front of the server whose X-Forwarded-For
+	// entries are trusted. With none, the client is taken to be the
+	// remote address of the connection.
+	TrustedHops int
+
+	// Buckets of clients idle for longer than this are dropped, and at
+	// most this many are kept, dropping the least recently used
+	IdleTimeout time.Duration
+	MaxClients  int
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element // Of lru, by key
+	lru     list.List                // Of *bucket, most recently used first
+	now     func() time.Time         // Replaceable for testing
+}
+
+// bucket holds the tokens of a single client for a single limit
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time // Of the last refill
+}
+
+// NewRateLimiter returns a RateLimiter applying the given default limit.
+func NewRateLimiter(def RateLimit) *RateLimiter {
+	return &RateLimiter{
+		Default:     def,
+		IdleTimeout: defaultIdleTimeout,
+		MaxClients:  defaultMaxClients,
+	}
+}
+
+// Handler returns a handler that serves requests using next as long as
+// the client is within its limit, and responds 429 Too Many Requests with
+// a Retry-After header otherwise.
+func (l *RateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, scope := l.Default, ""
+		if pl, ok := l.PerPath[r.URL.Path]; ok {
+			limit, scope = pl, r.URL.Path
+		}
+
+		if limit.Rate > 0 {
+			ok, retryAfter := l.allow(clientIP(r, l.TrustedHops)+" "+scope, limit)
+			if !ok {
+				secs := int(math.Ceil(retryAfter.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(secs))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests),
+					http.StatusTooManyRequests)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow takes a token from the bucket with the given key, returning false
+// and how long until a token is available if there is none
+func (l *RateLimiter) allow(key string, limit RateLimit) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.now != nil {
+		now = l.now()
+	}
+	if l.buckets == nil {
+		l.buckets = make(map[string]*list.Element)
+	}
+
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	var b *bucket
+	if elem, ok := l.buckets[key]; ok {
+		b = elem.Value.(*bucket)
+		l.lru.MoveToFront(elem)
+	} else {
+		l.makeRoom(now)
+		b = &bucket{key: key, tokens: burst, last: now}
+		l.buckets[key] = l.lru.PushFront(b)
+	}
+
+	// Refill for the time passed since the last request
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / limit.Rate
+		return false, time.Duration(wait * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// makeRoom drops idle buckets and, if the buckets are still at the limit,
+// the least recently used ones. Both are found at the back of the LRU list,
+// so only the buckets dropped are visited. Must be called with the lock
+// held.
+func (l *RateLimiter) makeRoom(now time.Time) {
+	idle := l.IdleTimeout
+	if idle <= 0 {
+		idle = defaultIdleTimeout
+	}
+	max := l.MaxClients
+	if max <= 0 {
+		max = defaultMaxClients
+	}
+
+	for elem := l.lru.Back(); elem != nil; elem = l.lru.Back() {
+		b := elem.Value.(*bucket)
+		if now.Sub(b.last) <= idle && len(l.buckets) < max {
+			break
+		}
+		l.lru.Remove(elem)
+		delete(l.buckets, b.key)
+	}
+}
+
+// clientIP returns the IP address of the client making the request. With
+// trusted proxies, it is the address that the outermost of them added to
+// X-Forwarded-For (the given number of hops from its end).
+func clientIP(r *http.Request, trustedHops int) string {
+	if trustedHops > 0 {
+		var hops []string
+		for _, header := range r.Header["X-Forwarded-For"] {
+			for _, hop := range strings.Split(header, ",") {
+				if hop = strings.TrimSpace(hop); hop != "" {
+					hops = append(hops, hop)
+				}
+			}
+		}
+		if len(hops) > 0 {
+			i := len(hops) - trustedHops
+			if i < 0 {
+				i = 0
+			}
+			return hops[i]
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,66 @@
+package urlshort
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewRateLimiter(RateLimit{})
+	l.now = func() time.Time { return now }
+	limit := RateLimit{Rate: 1, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a", limit); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	ok, wait := l.allow("a", limit)
+	if ok || wait != time.Second {
+		t.Errorf("request past the burst: allow() = %v, %v, want false, 1s", ok, wait)
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := l.allow("a", limit); !ok {
+		t.Error("request after refilling refused")
+	}
+}
+
+func TestRateLimiterEviction(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewRateLimiter(RateLimit{})
+	l.now = func() time.Time { return now }
+	l.MaxClients = 3
+	l.IdleTimeout = time.Minute
+	limit := RateLimit{Rate: 1, Burst: 1}
+
+	// Use up the tokens of a, b and c, then touch a again so that b is
+	// the least recently used
+	for _, key := range []string{"a", "b", "c"} {
+		l.allow(key, limit)
+		now = now.Add(time.Millisecond)
+	}
+	l.allow("a", limit)
+
+	l.allow("d", limit)
+	if len(l.buckets) != 3 || l.lru.Len() != 3 {
+		t.Fatalf("got %d buckets (%d in the list), want 3", len(l.buckets), l.lru.Len())
+	}
+	if _, ok := l.buckets["b"]; ok {
+		t.Error("least recently used bucket b kept")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := l.buckets[key]; !ok {
+			t.Errorf("bucket %s dropped", key)
+		}
+	}
+
+	// Idle buckets go as soon as a new client comes along
+	now = now.Add(2 * time.Minute)
+	l.allow("e", limit)
+	if len(l.buckets) != 1 || l.lru.Len() != 1 {
+		t.Errorf("got %d buckets (%d in the list) after idling, want 1",
+			len(l.buckets), l.lru.Len())
+	}
+}
@@ -0,0 +1,83 @@
+package urlshort
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Ways an entry can handle the query string of an incoming request
+const (
+	QueryDrop    = "drop"    // Ignore it (the default)
+	QueryForward = "forward" // Use it in place of the destination's query
+	QueryMerge   = "merge"   // Add it to the destination's, winning on clashes
+)
+
+// Status of redirects for entries that do not choose their own
+const defaultRedirectStatus = http.StatusSeeOther
+
+// checkRedirect returns an error if the entry's redirect status or query
+// handling is not one of those supported, or its split weights are bad
+func (e Entry) checkRedirect() error {
+	switch e.Status {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return fmt.Errorf("entry %s: unsupported redirect status %d", e.Path, e.Status)
+	}
+
+	switch e.Query {
+	case "", QueryDrop, QueryForward, QueryMerge:
+	default:
+		return fmt.Errorf("entry %s: unknown query handling '%s'", e.Path, e.Query)
+	}
+	return e.checkSplit()
+}
+
+// checkEntries returns the error of the first of the entries whose
+// redirect is not supported (see checkRedirect), if any
+func checkEntries(entries []Entry) error {
+	for _, e := range entries {
+		if err := e.checkRedirect(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redirectStatus returns the status to redirect to the entry's URL with
+func (e Entry) redirectStatus() int {
+	if e.Status == 0 {
+		return defaultRedirectStatus
+	}
+	return e.Status
+}
+
+// applyQuery returns the destination with the given incoming query string
+// handled as the entry asks
+func (e Entry) applyQuery(dest string, incoming string) string {
+	if incoming == "" || e.Query == "" || e.Query == QueryDrop {
+		return dest
+	}
+
+	u, err := url.Parse(dest)
+	if err != nil {
+		return dest
+	}
+
+	switch e.Query {
+	case QueryForward:
+		u.RawQuery = incoming
+	case QueryMerge:
+		in, err := url.ParseQuery(incoming)
+		if err != nil {
+			return dest
+		}
+		q := u.Query()
+		for k, v := range in {
+			q[k] = v
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
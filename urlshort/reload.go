@@ -0,0 +1,314 @@
+package urlshort
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// FileHandler is an http.Handler serving the entries in a file, which can
+// be reloaded while serving. A reload swaps in the new entries all at once,
+// so requests never see a partially loaded file, and a file that fails to
+// parse leaves the previously loaded entries in place.
+type FileHandler struct {
+	fileName string
+	parse    func([]byte) ([]Entry, error)
+	opts     Options
+	store    swapStore
+	handler  http.HandlerFunc
+
+	mu      sync.Mutex // Serializes reloads
+	modTime time.Time  // Of the file as last loaded
+	size    int64
+	err     error // Of the last reload
+}
+
+// NewFileHandler loads the entries in the given file and returns a handler
+// serving them, calling the fallback handler for any other path. The
+// format of the file is picked by its extension: .json for JSON, .yaml or
+// .yml for YAML, .csv for CSV, .html or .htm for a bookmark file (see
+// ParseBookmarks), and the flat format of ParseFlat otherwise.
+func NewFileHandler(fileName string, fallback http.Handler) (*FileHandler, error) {
+	return NewFileHandlerWithOptions(fileName, fallback, Options{})
+}
+
+// NewFileHandlerWithOptions is the same as NewFileHandler but with the
+// behavior of the handler adjusted by the given options.
+func NewFileHandlerWithOptions(fileName string, fallback http.Handler,
+	opts Options) (*FileHandler, error) {
+
+	return NewFileHandlerWithParser(fileName, ParserFor(fileName), fallback, opts)
+}
+
+// NewFileHandlerWithParser is the same as NewFileHandlerWithOptions but
+// parses the file with the given function, whatever its extension.
+func NewFileHandlerWithParser(fileName string, parse func([]byte) ([]Entry, error),
+	fallback http.Handler, opts Options) (*FileHandler, error) {
+
+	h := &FileHandler{fileName: fileName, parse: parse, opts: opts}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	h.handler = StoreHandlerWithOptions(&h.store, fallback, opts)
+	return h, nil
+}
+
+// ParserFor returns the parse function for the format of the given file,
+// going by its extension as described for NewFileHandler.
+func ParserFor(fileName string) func([]byte) ([]Entry, error) {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".json":
+		return ParseJSON
+	case ".yaml", ".yml":
+		return ParseYAML
+	case ".csv":
+		return ParseCSV
+	case ".html", ".htm":
+		return ParseBookmarks
+	default:
+		return ParseFlat
+	}
+}
+
+func (h *FileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.ServeHTTP(w, r)
+}
+
+// Store returns the store holding the currently loaded entries.
+func (h *FileHandler) Store() Store {
+	return &h.store
+}
+
+// Reload reads and parses the file again (validating it too if the
+// handler's options ask to refuse invalid entries) and, if that succeeds,
+// swaps in its entries. On error the previously loaded entries are kept.
+func (h *FileHandler) Reload() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.err = h.reload()
+	return h.err
+}
+
+// Err returns the error of the last reload of the file, nil if it
+// succeeded.
+func (h *FileHandler) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// Loaded returns nil once entries have been loaded from the file, else an
+// error. Unlike Err it stays nil when a later reload fails, as the entries
+// last loaded are still served.
+func (h *FileHandler) Loaded() error {
+	if h.store.current.Load() == nil {
+		return fmt.Errorf("no entries loaded from %s", h.fileName)
+	}
+	return nil
+}
+
+// reload does the work of Reload
+func (h *FileHandler) reload() error {
+	info, err := os.Stat(h.fileName)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(h.fileName)
+	if err != nil {
+		return err
+	}
+
+	// Remember this version even if it is bad, so that Watch only
+	// tries again once the file changes
+	h.modTime = info.ModTime()
+	h.size = info.Size()
+
+	entries, err := h.parse(data)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %v", h.fileName, err)
+	}
+	if err = checkEntries(entries); err != nil {
+		return fmt.Errorf("error loading %s: %v", h.fileName, err)
+	}
+	if h.opts.RefuseInvalid {
+		if err = h.validate(data, entries); err != nil {
+			return fmt.Errorf("error validating %s: %v", h.fileName, err)
+		}
+	}
+
+	h.store.swap(NewMemoryStoreFromEntries(entries))
+	return nil
+}
+
+// validate returns a *ValidationError if the given entries, parsed from
+// the given file data, have any problems
+func (h *FileHandler) validate(data []byte, entries []Entry) error {
+	var problems []Problem
+	if ext := strings.ToLower(filepath.Ext(h.fileName)); ext == ".yaml" || ext == ".yml" {
+		problems, _ = ValidateYAML(data, h.opts.OwnHosts...) // Parsed already
+	} else {
+		problems = Validate(entries, h.opts.OwnHosts...)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Watch reloads the file in the background whenever it is seen to change,
+// checking every interval, and whenever the process receives SIGHUP. Reload
+// errors are logged. Watching continues until the returned stop function is
+// called.
+func (h *FileHandler) Watch(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer ticker.Stop()
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-done:
+				return
+			case <-hup:
+				h.reloadAndLog()
+			case <-ticker.C:
+				if h.changed() {
+					h.reloadAndLog()
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// changed returns whether the file's modification time or size differs
+// from when it was last loaded
+func (h *FileHandler) changed() bool {
+	info, err := os.Stat(h.fileName)
+	if err != nil {
+		return true // Let the reload report the problem
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !info.ModTime().Equal(h.modTime) || info.Size() != h.size
+}
+
+// reloadAndLog reloads the file, logging the outcome
+func (h *FileHandler) reloadAndLog() {
+	if err := h.Reload(); err != nil {
+		log.Printf("urlshort: keeping previous entries, reload failed: %v", err)
+		return
+	}
+	log.Printf("urlshort: reloaded %s", h.fileName)
+}
+
+// swapStore is a Store that passes every call on to a MemoryStore which can
+// be replaced atomically. Changes made through it since the entries were
+// loaded, such as uses counted and dead entries swept, are carried over to
+// the entries swapped in, so that a reload does not bring used up entries
+// back to life.
+type swapStore struct {
+	current atomic.Value // *MemoryStore
+
+	mu      sync.Mutex       // Serializes changes and swaps
+	uses    map[string]int   // Use counts put, by key
+	deleted map[string]Entry // Entries deleted, by key
+}
+
+// swap replaces the entries with those of the given store, after carrying
+// over the changes made so far. A use count is only carried over if higher
+// than the new entry's, and a deletion only if the new entry is the one
+// deleted (other than its use count), so that editing an entry in the file
+// revives it with the uses it had.
+func (s *swapStore) swap(m *MemoryStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reindex := false
+	for key, old := range s.deleted {
+		e, ok := m.entries[key]
+		switch {
+		case ok && sameButUses(e, old):
+			delete(m.entries, key)
+			reindex = reindex || isPattern(key)
+			continue
+		case ok && old.Uses > e.Uses:
+			e.Uses = old.Uses
+			m.entries[key] = e
+			reindex = reindex || isPattern(key)
+		}
+		delete(s.deleted, key)
+	}
+	for key, uses := range s.uses {
+		e, ok := m.entries[key]
+		if !ok {
+			delete(s.uses, key)
+			continue
+		}
+		if uses > e.Uses {
+			e.Uses = uses
+			m.entries[key] = e
+			reindex = reindex || isPattern(key)
+		}
+	}
+	if reindex {
+		m.indexRoutes()
+	}
+	s.current.Store(m)
+}
+
+func (s *swapStore) get() *MemoryStore { return s.current.Load().(*MemoryStore) }
+
+func (s *swapStore) Lookup(key string) (Entry, bool) { return s.get().Lookup(key) }
+func (s *swapStore) List() []Entry                   { return s.get().List() }
+
+func (s *swapStore) Put(e Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.get().Put(e); err != nil {
+		return err
+	}
+	if s.uses == nil {
+		s.uses = make(map[string]int)
+	}
+	s.uses[e.Key()] = e.Uses
+	delete(s.deleted, e.Key())
+	return nil
+}
+
+func (s *swapStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.get()
+	old, ok := m.Lookup(key)
+	if err := m.Delete(key); err != nil || !ok {
+		return err
+	}
+	if s.deleted == nil {
+		s.deleted = make(map[string]Entry)
+	}
+	s.deleted[key] = old
+	delete(s.uses, key)
+	return nil
+}
+
+func (s *swapStore) Match(host, path string) (Entry, string, bool) {
+	return s.get().Match(host, path)
+}
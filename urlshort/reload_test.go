@@ -0,0 +1,90 @@
+package urlshort
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloadKeepsUsesAndSweeps(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "links.yaml")
+	write := func(yml string) {
+		if err := ioutil.WriteFile(fileName, []byte(yml), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(h http.Handler, path string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	write(`
+- {path: /once, url: https://example.com/once, max_uses: 1}
+- {path: /swept, url: https://example.com/swept, max_uses: 1}
+`)
+	h, err := NewFileHandler(fileName, http.NotFoundHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/once", "/swept"} {
+		if code := get(h, path); code != http.StatusSeeOther {
+			t.Fatalf("first GET %s: status %d, want %d", path, code, http.StatusSeeOther)
+		}
+	}
+	if n, err := Sweep(h.Store(), time.Now()); n != 2 || err != nil {
+		t.Fatalf("Sweep() = %d, %v, want 2, nil", n, err)
+	}
+	if err = h.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if code := get(h, "/once"); code != http.StatusNotFound {
+		t.Errorf("used up entry after reload: status %d, want %d", code, http.StatusNotFound)
+	}
+
+	// Entries edited in the file come back, keeping their use counts
+	write(`
+- {path: /once, url: https://example.com/once, max_uses: 2}
+- {path: /swept, url: https://example.com/swept, max_uses: 1}
+`)
+	if err = h.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if code := get(h, "/swept"); code != http.StatusNotFound {
+		t.Errorf("swept entry after reload: status %d, want %d", code, http.StatusNotFound)
+	}
+	if code := get(h, "/once"); code != http.StatusSeeOther {
+		t.Errorf("entry given another use: status %d, want %d", code, http.StatusSeeOther)
+	}
+	if code := get(h, "/once"); code != http.StatusGone {
+		t.Errorf("entry used up again: status %d, want %d", code, http.StatusGone)
+	}
+}
+
+func TestFailedReloadStaysLoaded(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "links.yaml")
+	err := ioutil.WriteFile(fileName, []byte("- {path: /a, url: https://example.com}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewFileHandler(fileName, http.NotFoundHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(fileName, []byte("- {path: /a, url: "), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.Reload(); err == nil {
+		t.Fatal("Reload() accepted bad YAML")
+	}
+	if err = h.Loaded(); err != nil {
+		t.Errorf("Loaded() = %v after a failed reload, want nil", err)
+	}
+	if _, ok := h.Store().Lookup("/a"); !ok {
+		t.Error("entry loaded before the failed reload is gone")
+	}
+}
@@ -0,0 +1,243 @@
+package urlshort
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+)
+
+// Header set on responses of a Router to the name of the source whose
+// entry served the request
+const SourceHeader = "X-Urlshort-Source"
+
+// Source is a named store of entries served by a Router.
+type Source struct {
+	Name  string
+	Store Store
+}
+
+// MapSource returns a source of the given paths to URLs.
+func MapSource(name string, pathsToUrls map[string]string) Source {
+	return Source{Name: name, Store: NewMemoryStore(pathsToUrls)}
+}
+
+// ParsedSource returns a source of the entries parsed from the given data
+// by the given parse function, such as ParseYAML or ParseJSON. Entries with
+// an unsupported redirect status, query handling or weight are refused.
+func ParsedSource(name string, parse func([]byte) ([]Entry, error),
+	data []byte) (Source, error) {
+
+	entries, err := parse(data)
+	if err != nil {
+		return Source{}, err
+	}
+	if err = checkEntries(entries); err != nil {
+		return Source{}, err
+	}
+	return Source{Name: name, Store: NewMemoryStoreFromEntries(entries)}, nil
+}
+
+// Shadow is an entry of a source hidden by an entry with the same path
+// (and host) in a source earlier in the order of a Router.
+type Shadow struct {
+	Key      string `json:"key"`
+	Source   string `json:"source"`     // Of the entry served
+	URL      string `json:"url"`        // That it redirects to
+	Shadowed string `json:"shadowed"`   // Source of the hidden entry
+	Hidden   string `json:"hidden_url"` // URL of the hidden entry
+}
+
+// Router serves the entries of several sources as a single table, in
+// which an entry of a source hides any with the same path (and host) in
+// the sources after it. Pattern entries are tried source by source too,
+// so a match in an earlier source wins over a more specific pattern in a
+// later one. Responses for entries name the source they came from in the
+// SourceHeader header.
+type Router struct {
+	table   routerTable
+	handler http.HandlerFunc
+}
+
+// NewRouter returns a Router for the given sources, in order of priority,
+// calling the fallback handler for paths none of them has.
+func NewRouter(sources []Source, fallback http.Handler) *Router {
+	return NewRouterWithOptions(sources, fallback, Options{})
+}
+
+// NewRouterWithOptions is the same as NewRouter but with the behavior of
+// the router adjusted by the given options, as for StoreHandlerWithOptions.
+func NewRouterWithOptions(sources []Source, fallback http.Handler,
+	opts Options) *Router {
+
+	r := &Router{table: routerTable{sources: sources}}
+	r.handler = StoreHandlerWithOptions(r.table, fallback, opts)
+	return r
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if entry, _, _, _, ok := resolveRequest(rt.table, r); ok {
+		if name, found := rt.table.sourceOf(entry.Key()); found {
+			w.Header().Set(SourceHeader, name)
+		}
+	}
+	rt.handler.ServeHTTP(w, r)
+}
+
+// Store returns the merged table of the router's sources as a Store.
+// Changes made through it go to the source of the entry changed, or the
+// first source for new entries.
+func (rt *Router) Store() Store {
+	return rt.table
+}
+
+// Shadowed returns the entries of the router's sources hidden by those of
+// earlier sources, sorted by key and then source order.
+func (rt *Router) Shadowed() []Shadow {
+	var shadows []Shadow
+	winners := make(map[string]Source)
+	entries := make(map[string]Entry)
+	for _, src := range rt.table.sources {
+		for _, e := range src.Store.List() {
+			key := e.Key()
+			winner, hidden := winners[key]
+			if !hidden {
+				winners[key], entries[key] = src, e
+				continue
+			}
+			shadows = append(shadows, Shadow{
+				Key:      key,
+				Source:   winner.Name,
+				URL:      entries[key].URL,
+				Shadowed: src.Name,
+				Hidden:   e.URL,
+			})
+		}
+	}
+
+	sort.SliceStable(shadows, func(i, j int) bool {
+		return shadows[i].Key < shadows[j].Key
+	})
+	return shadows
+}
+
+var errNoSources = errors.New("urlshort: router has no sources")
+
+// ErrShadowed is returned when changing an entry of a source that is hidden
+// by an entry of an earlier source (see GuardShadowed).
+var ErrShadowed = errors.New("urlshort: path is served by an earlier source")
+
+// GuardShadowed returns the given store of a source of a Router, such as
+// the one links created through the APIs go into, guarded against entries
+// that would be hidden by those of the given earlier sources: such entries
+// are looked up in the earlier sources, so that handlers see their paths
+// as taken, and adding or changing them fails with ErrShadowed.
+func GuardShadowed(store Store, earlier []Source) Store {
+	return &guardedStore{Store: store, earlier: routerTable{sources: earlier}}
+}
+
+// guardedStore is the Store returned by GuardShadowed
+type guardedStore struct {
+	Store
+	earlier routerTable
+}
+
+func (g *guardedStore) Lookup(key string) (Entry, bool) {
+	if e, ok := g.earlier.Lookup(key); ok {
+		return e, true
+	}
+	return g.Store.Lookup(key)
+}
+
+func (g *guardedStore) Put(e Entry) error {
+	if g.earlier.indexOf(e.Key()) >= 0 {
+		return ErrShadowed
+	}
+	return g.Store.Put(e)
+}
+
+// Delete removes the store's own entry with the key, which may be hidden,
+// failing with ErrShadowed only if it has none but an earlier source does
+func (g *guardedStore) Delete(key string) error {
+	if _, own := g.Store.Lookup(key); !own && g.earlier.indexOf(key) >= 0 {
+		return ErrShadowed
+	}
+	return g.Store.Delete(key)
+}
+
+// Match lets resolve use the compiled routes of the guarded store, if any
+func (g *guardedStore) Match(host, path string) (Entry, string, bool) {
+	return matchStore(g.Store, host, path)
+}
+
+// routerTable is the Store of a Router, merging those of its sources
+type routerTable struct {
+	sources []Source
+}
+
+// sourceOf returns the name of the first source with an entry with the
+// given key, if any
+func (t routerTable) sourceOf(key string) (string, bool) {
+	i := t.indexOf(key)
+	if i < 0 {
+		return "", false
+	}
+	return t.sources[i].Name, true
+}
+
+// indexOf returns the index of the first source with an entry with the
+// given key, or -1
+func (t routerTable) indexOf(key string) int {
+	for i, src := range t.sources {
+		if _, ok := src.Store.Lookup(key); ok {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t routerTable) Lookup(key string) (Entry, bool) {
+	if i := t.indexOf(key); i >= 0 {
+		return t.sources[i].Store.Lookup(key)
+	}
+	return Entry{}, false
+}
+
+func (t routerTable) Put(e Entry) error {
+	i := t.indexOf(e.Key())
+	if i < 0 {
+		if len(t.sources) == 0 {
+			return errNoSources
+		}
+		i = 0
+	}
+	return t.sources[i].Store.Put(e)
+}
+
+func (t routerTable) Delete(key string) error {
+	if i := t.indexOf(key); i >= 0 {
+		return t.sources[i].Store.Delete(key)
+	}
+	return nil
+}
+
+// Match returns the first match of the pattern entries of the sources, in
+// order, so that each source's compiled routes are used where it has them
+// and earlier sources win as they do for exact entries
+func (t routerTable) Match(host, path string) (Entry, string, bool) {
+	for _, src := range t.sources {
+		if e, dest, ok := matchStore(src.Store, host, path); ok {
+			return e, dest, true
+		}
+	}
+	return Entry{}, "", false
+}
+
+func (t routerTable) List() []Entry {
+	merged := make(map[string]Entry)
+	for i := len(t.sources) - 1; i >= 0; i-- {
+		for _, e := range t.sources[i].Store.List() {
+			merged[e.Key()] = e
+		}
+	}
+	return sortedEntries(merged)
+}
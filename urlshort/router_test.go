@@ -0,0 +1,57 @@
+package urlshort
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// countingStore is a MemoryStore counting the calls to List, which resolve
+// makes to compile the routes of stores that do not keep them compiled
+type countingStore struct {
+	*MemoryStore
+	lists int
+}
+
+func (s *countingStore) List() []Entry {
+	s.lists++
+	return s.MemoryStore.List()
+}
+
+func TestRouterMatchesSourcesInOrder(t *testing.T) {
+	first := &countingStore{MemoryStore: NewMemoryStoreFromEntries([]Entry{
+		{Path: "/docs/*", URL: "https://first.example.com"},
+	})}
+	second := &countingStore{MemoryStore: NewMemoryStoreFromEntries([]Entry{
+		{Path: "/docs/api/*", URL: "https://second.example.com"},
+		{Path: "/blog/*", URL: "https://blog.example.com"},
+	})}
+	router := NewRouter([]Source{
+		{Name: "first", Store: first},
+		{Name: "second", Store: second},
+	}, http.NotFoundHandler())
+
+	tests := []struct {
+		path   string
+		want   string
+		source string
+	}{
+		{"/docs/api/x", "https://first.example.com/api/x", "first"},
+		{"/blog/post", "https://blog.example.com/post", "second"},
+		{"/none", "", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if got := w.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s: Location = %s, want %s", tt.path, got, tt.want)
+		}
+		if got := w.Header().Get(SourceHeader); got != tt.source {
+			t.Errorf("%s: %s = %s, want %s", tt.path, SourceHeader, got, tt.source)
+		}
+	}
+
+	if first.lists+second.lists != 0 {
+		t.Errorf("routes compiled on requests: %d List calls", first.lists+second.lists)
+	}
+}
@@ -0,0 +1,207 @@
+package urlshort
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Kinds of segments in a pattern path, in order of decreasing specificity
+const (
+	segLiteral = iota // Matches itself only
+	segParam          // {name} matches any single segment
+	segRest           // * (final only) matches the remaining path, if any
+)
+
+type segment struct {
+	kind  int
+	value string // Literal text or parameter name
+}
+
+// route is a pattern entry compiled for matching against request paths
+type route struct {
+	entry Entry
+	segs  []segment
+}
+
+// isPattern returns whether the path of an entry is a pattern, that is it
+// has {name} parameters or ends with /* to match any path under it
+func isPattern(path string) bool {
+	return strings.Contains(path, "{") || strings.HasSuffix(path, "/*")
+}
+
+// compileRoute compiles the path of the given pattern entry
+func compileRoute(e Entry) (route, error) {
+	if !strings.HasPrefix(e.Path, "/") {
+		return route{}, fmt.Errorf("pattern '%s' must start with '/'", e.Path)
+	}
+
+	parts := strings.Split(e.Path[1:], "/")
+	r := route{entry: e, segs: make([]segment, 0, len(parts))}
+	for i, part := range parts {
+		switch {
+		case part == "*":
+			if i != len(parts)-1 {
+				return route{}, fmt.Errorf("pattern '%s' may only have '*' at the end",
+					e.Path)
+			}
+			r.segs = append(r.segs, segment{kind: segRest})
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name := part[1 : len(part)-1]
+			if name == "" || strings.ContainsAny(name, "{}") {
+				return route{}, fmt.Errorf("pattern '%s' has a bad parameter '%s'",
+					e.Path, part)
+			}
+			r.segs = append(r.segs, segment{kind: segParam, value: name})
+		case strings.ContainsAny(part, "{}"):
+			return route{}, fmt.Errorf("pattern '%s' has a bad parameter '%s'",
+				e.Path, part)
+		default:
+			r.segs = append(r.segs, segment{kind: segLiteral, value: part})
+		}
+	}
+	return r, nil
+}
+
+// match returns the URL the route redirects the given (unescaped) path
+// to, if it matches. Parameters are substituted into the URL and, for a
+// route ending with *, the rest of the path is appended to it, escaped
+// segment by segment so that it cannot add a query or fragment.
+func (r route) match(path string) (string, bool) {
+	if !strings.HasPrefix(path, "/") {
+		return "", false
+	}
+	parts := strings.Split(path[1:], "/")
+
+	dest := r.entry.URL
+	for i, seg := range r.segs {
+		if seg.kind == segRest {
+			rest := ""
+			if i < len(parts) {
+				for _, part := range parts[i:] {
+					rest += "/" + url.PathEscape(part)
+				}
+			}
+			return strings.TrimSuffix(dest, "/") + rest, true
+		}
+		if i >= len(parts) {
+			return "", false
+		}
+
+		switch seg.kind {
+		case segLiteral:
+			if parts[i] != seg.value {
+				return "", false
+			}
+		case segParam:
+			if parts[i] == "" {
+				return "", false
+			}
+			dest = strings.Replace(dest, "{"+seg.value+"}",
+				url.PathEscape(parts[i]), -1)
+		}
+	}
+
+	if len(parts) != len(r.segs) {
+		return "", false
+	}
+	return dest, true
+}
+
+// routeLess orders routes from most to least specific. Segments are compared
+// in turn, with literals beating parameters beating *, then longer routes
+// beat shorter ones. Routes alike in all that are ordered by path so that
+// the order never depends on map iteration.
+func routeLess(a, b route) bool {
+	for i := 0; i < len(a.segs) && i < len(b.segs); i++ {
+		if a.segs[i].kind != b.segs[i].kind {
+			return a.segs[i].kind < b.segs[i].kind
+		}
+	}
+	if len(a.segs) != len(b.segs) {
+		return len(a.segs) > len(b.segs)
+	}
+	return a.entry.Path < b.entry.Path
+}
+
+// compileRoutes returns the routes of the pattern entries among the given
+// ones, most specific first, skipping patterns that fail to compile
+func compileRoutes(entries []Entry) []route {
+	var routes []route
+	for _, e := range entries {
+		if !isPattern(e.Path) {
+			continue
+		}
+		if r, err := compileRoute(e); err == nil {
+			routes = append(routes, r)
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool { return routeLess(routes[i], routes[j]) })
+	return routes
+}
+
+// matchRoutes returns the entry of the first of the given routes matching
+// the path, along with the URL to redirect to. Routes for the given
+// (normalized) host are tried before those for any host.
+func matchRoutes(routes []route, host, path string) (Entry, string, bool) {
+	if host != "" {
+		for _, r := range routes {
+			if r.entry.Host != host {
+				continue
+			}
+			if dest, ok := r.match(path); ok {
+				return r.entry, dest, true
+			}
+		}
+	}
+	for _, r := range routes {
+		if r.entry.Host != "" {
+			continue
+		}
+		if dest, ok := r.match(path); ok {
+			return r.entry, dest, true
+		}
+	}
+	return Entry{}, "", false
+}
+
+// routeMatcher is implemented by stores that keep their pattern entries
+// compiled, saving resolve from compiling them on every request
+type routeMatcher interface {
+	Match(host, path string) (Entry, string, bool)
+}
+
+// resolve returns the entry in the store serving the given host and path
+// along with the URL to redirect to. Exact entries win over patterns, which
+// are tried from most to least specific, and at each step entries for the
+// host win over those for any host.
+func resolve(store Store, host, path string) (Entry, string, bool) {
+	if e, ok := lookupExact(store, host, path); ok {
+		return e, e.URL, true
+	}
+	return matchStore(store, normalizeHost(host), path)
+}
+
+// lookupExact returns the entry in the store with exactly the given path,
+// for the host or else for any host, leaving out patterns
+func lookupExact(store Store, host, path string) (Entry, bool) {
+	if host = normalizeHost(host); host != "" {
+		if e, ok := store.Lookup(EntryKey(host, path)); ok && !isPattern(e.Path) {
+			return e, true
+		}
+	}
+	if e, ok := store.Lookup(path); ok && !isPattern(e.Path) {
+		return e, true
+	}
+	return Entry{}, false
+}
+
+// matchStore returns the pattern entry of the store matching the given
+// host and path, using its compiled routes if it keeps them
+func matchStore(store Store, host, path string) (Entry, string, bool) {
+	if m, ok := store.(routeMatcher); ok {
+		return m.Match(host, path)
+	}
+	return matchRoutes(compileRoutes(store.List()), host, path)
+}
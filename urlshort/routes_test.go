@@ -0,0 +1,122 @@
+package urlshort
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompileRoute(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"/docs/*", false},
+		{"/u/{user}", false},
+		{"/u/{user}/repo/{repo}/*", false},
+		{"docs/*", true},
+		{"/a/*/b", true},
+		{"/u/{}", true},
+		{"/u/x{user}", true},
+		{"/u/{{user}}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			_, err := compileRoute(Entry{Path: tt.path, URL: "https://example.com"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("compileRoute() = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRouteMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		url     string
+		path    string
+		want    string
+		ok      bool
+	}{
+		{"/docs/*", "https://d.example.com", "/docs/a/b", "https://d.example.com/a/b", true},
+		{"/docs/*", "https://d.example.com/", "/docs", "https://d.example.com", true},
+		{"/docs/*", "https://d.example.com", "/doc/a", "", false},
+		{"/docs/*", "https://d.example.com", "/docs/a?admin=1", "https://d.example.com/a%3Fadmin=1", true},
+		{"/docs/*", "https://d.example.com", "/docs/a#top", "https://d.example.com/a%23top", true},
+		{"/docs/*", "https://d.example.com", "/docs/a b/c", "https://d.example.com/a%20b/c", true},
+		{"/u/{user}", "https://x.example.com/{user}", "/u/ann", "https://x.example.com/ann", true},
+		{"/u/{user}", "https://x.example.com/{user}", "/u/a%20b", "https://x.example.com/a%2520b", true},
+		{"/u/{user}", "https://x.example.com/{user}", "/u/a b", "https://x.example.com/a%20b", true},
+		{"/u/{user}", "https://x.example.com/{user}", "/u/", "", false},
+		{"/u/{user}", "https://x.example.com/{user}", "/u/ann/more", "", false},
+		{"/{a}/{b}", "https://x.example.com/{b}/{a}", "/1/2", "https://x.example.com/2/1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			r, err := compileRoute(Entry{Path: tt.pattern, URL: tt.url})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := r.match(tt.path)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("match() = %s, %v, want %s, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	store := NewMemoryStoreFromEntries([]Entry{
+		{Path: "/docs/*", URL: "https://docs.example.com"},
+		{Path: "/docs/api/*", URL: "https://api.example.com"},
+		{Path: "/docs/{page}", URL: "https://pages.example.com/{page}"},
+		{Path: "/docs/faq", URL: "https://faq.example.com"},
+		{Host: "go.example.com", Path: "/docs/*", URL: "https://go.example.com/docs"},
+	})
+
+	tests := []struct {
+		host string
+		path string
+		want string
+	}{
+		{"", "/docs/faq", "https://faq.example.com"},
+		{"", "/docs/intro", "https://pages.example.com/intro"},
+		{"", "/docs/api/v1", "https://api.example.com/v1"},
+		{"", "/docs/a/b", "https://docs.example.com/a/b"},
+		{"go.example.com", "/docs/a/b", "https://go.example.com/docs/a/b"},
+		{"GO.example.com:8080", "/docs/intro", "https://go.example.com/docs/intro"},
+		{"", "/blog", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host+tt.path, func(t *testing.T) {
+			_, got, ok := resolve(store, tt.host, tt.path)
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("resolve() = %s, %v, want %s", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreHandlerEscapesRest(t *testing.T) {
+	store := NewMemoryStoreFromEntries([]Entry{{Path: "/docs/*", URL: "https://docs.example.com"}})
+	handler := StoreHandler(store, http.NotFoundHandler())
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/docs/a%3Fadmin=1", "https://docs.example.com/a%3Fadmin=1"},
+		{"/docs/a%23top", "https://docs.example.com/a%23top"},
+		{"/docs/a/b", "https://docs.example.com/a/b"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if got := w.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s: Location = %s, want %s", tt.target, got, tt.want)
+		}
+	}
+}
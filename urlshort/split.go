@@ -0,0 +1,228 @@
+package urlshort
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Prefix of the cookies pinning clients to a destination of sticky entries,
+// and how long they last
+const (
+	splitCookiePrefix = "urlshort_split_"
+	splitCookieMaxAge = 30 * 24 * time.Hour
+)
+
+// Target is a destination an entry splits requests to, along with its URL.
+type Target struct {
+	URL    string `json:"url" yaml:"url"`
+	Weight int    `json:"weight,omitempty" yaml:"weight,omitempty"` // 1 if not set
+}
+
+// Health records which destinations are down, for handlers to avoid them
+// when an entry has others to choose from. It is safe for concurrent use.
+type Health struct {
+	mu   sync.RWMutex
+	down map[string]bool
+}
+
+// NewHealth returns a Health with every destination up.
+func NewHealth() *Health {
+	return &Health{down: make(map[string]bool)}
+}
+
+// Down returns whether the given destination is marked down.
+func (h *Health) Down(url string) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.down[url]
+}
+
+// SetDown marks the given destination down, or up again.
+func (h *Health) SetDown(url string, down bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if down {
+		h.down[url] = true
+	} else {
+		delete(h.down, url)
+	}
+}
+
+// Update marks the destinations in the report down if they are broken and
+// up otherwise.
+func (h *Health) Update(report *CheckReport) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range report.Results {
+		switch {
+		case r.Skipped:
+		case r.Broken:
+			h.down[r.URL] = true
+		default:
+			delete(h.down, r.URL)
+		}
+	}
+}
+
+// Watch checks the destinations of the entries in the store every interval
+// and updates the health with the results, until the returned stop
+// function is called.
+func (h *Health) Watch(store Store, interval time.Duration,
+	opts CheckOptions) (stop func()) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			report := Check(ctx, store, opts)
+			if ctx.Err() != nil {
+				return // Stopped part way, so the report is no good
+			}
+			h.Update(report)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return cancel
+}
+
+// destinations returns the URLs the entry splits requests to, starting
+// with its own, and their weights
+func (e Entry) destinations() []Target {
+	targets := make([]Target, 0, 1+len(e.Split))
+	targets = append(targets, Target{URL: e.URL, Weight: e.Weight})
+	targets = append(targets, e.Split...)
+	for i := range targets {
+		if targets[i].Weight == 0 {
+			targets[i].Weight = 1
+		}
+	}
+	return targets
+}
+
+// allURLs returns every URL the entry may redirect to
+func (e Entry) allURLs() []string {
+	urls := []string{e.URL}
+	for _, t := range e.Split {
+		urls = append(urls, t.URL)
+	}
+	return append(urls, e.Fallbacks...)
+}
+
+// checkSplit returns an error if the entry's split weights are negative
+func (e Entry) checkSplit() error {
+	if e.Weight < 0 {
+		return fmt.Errorf("entry %s: negative weight %d", e.Path, e.Weight)
+	}
+	for _, t := range e.Split {
+		if t.Weight < 0 {
+			return fmt.Errorf("entry %s: negative weight %d for %s",
+				e.Path, t.Weight, t.URL)
+		}
+	}
+	return nil
+}
+
+// pickURL returns the URL to redirect the request for the entry to. The
+// entry's destinations that are not down are picked from by weight (keeping
+// to the one in the client's cookie for sticky entries), else the first of
+// its fallbacks not down, else its own URL.
+func (e Entry) pickURL(w http.ResponseWriter, r *http.Request, opts Options) string {
+	if len(e.Split) == 0 && len(e.Fallbacks) == 0 {
+		return e.URL
+	}
+
+	var up []Target
+	total := 0
+	for _, t := range e.destinations() {
+		if !opts.Health.Down(t.URL) {
+			up = append(up, t)
+			total += t.Weight
+		}
+	}
+	if len(up) == 0 {
+		for _, url := range e.Fallbacks {
+			if !opts.Health.Down(url) {
+				return url
+			}
+		}
+		return e.URL
+	}
+
+	cookieName := splitCookiePrefix + keyHash(e.Key())
+	if e.Sticky {
+		if c, err := r.Cookie(cookieName); err == nil {
+			for _, t := range up {
+				if keyHash(t.URL) == c.Value {
+					return t.URL
+				}
+			}
+		}
+	}
+
+	if total <= 0 { // Only with negative weights, which loading refuses
+		return up[0].URL
+	}
+	random := opts.Random
+	if random == nil {
+		random = rand.Intn
+	}
+	n := random(total)
+	picked := up[len(up)-1]
+	for _, t := range up {
+		if n < t.Weight {
+			picked = t
+			break
+		}
+		n -= t.Weight
+	}
+
+	if e.Sticky {
+		http.SetCookie(w, &http.Cookie{
+			Name:     cookieName,
+			Value:    keyHash(picked.URL),
+			Path:     "/",
+			MaxAge:   int(splitCookieMaxAge / time.Second),
+			HttpOnly: true,
+		})
+	}
+	return picked.URL
+}
+
+// expandURL returns the given URL of the entry with the parameters of its
+// pattern path, if any, filled in from the request path as for its own URL
+func (e Entry) expandURL(url, path string) string {
+	if !isPattern(e.Path) {
+		return url
+	}
+	e.URL = url
+	r, err := compileRoute(e)
+	if err != nil {
+		return url
+	}
+	if dest, ok := r.match(path); ok {
+		return dest
+	}
+	return url
+}
+
+// keyHash returns a short hash of the given string, for use in cookies
+func keyHash(s string) string {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return strconv.FormatUint(uint64(h.Sum32()), 36)
+}
@@ -0,0 +1,157 @@
+package urlshort
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fixedRandom returns a source of random numbers always returning n
+func fixedRandom(n int) func(int) int {
+	return func(int) int { return n }
+}
+
+func TestPickURL(t *testing.T) {
+	split := Entry{
+		Path:  "/split",
+		URL:   "https://a.example.com",
+		Split: []Target{{URL: "https://b.example.com", Weight: 3}},
+	}
+	withFallbacks := split
+	withFallbacks.Fallbacks = []string{"https://c.example.com", "https://d.example.com"}
+
+	tests := []struct {
+		name   string
+		entry  Entry
+		down   []string
+		random int
+		want   string
+	}{
+		{"plain entry", Entry{Path: "/a", URL: "https://a.example.com"}, nil, 0,
+			"https://a.example.com"},
+		{"first weight", split, nil, 0, "https://a.example.com"},
+		{"second weight start", split, nil, 1, "https://b.example.com"},
+		{"second weight end", split, nil, 3, "https://b.example.com"},
+		{"skips down", split, []string{"https://a.example.com"}, 0,
+			"https://b.example.com"},
+		{"all down, no fallbacks", split,
+			[]string{"https://a.example.com", "https://b.example.com"}, 0,
+			"https://a.example.com"},
+		{"all down, first fallback", withFallbacks,
+			[]string{"https://a.example.com", "https://b.example.com"}, 0,
+			"https://c.example.com"},
+		{"all down, fallback not down", withFallbacks,
+			[]string{"https://a.example.com", "https://b.example.com",
+				"https://c.example.com"}, 0,
+			"https://d.example.com"},
+		{"weights adding up to zero", Entry{
+			Path:   "/neg",
+			URL:    "https://a.example.com",
+			Weight: -1,
+			Split:  []Target{{URL: "https://b.example.com"}},
+		}, nil, 0, "https://a.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := NewHealth()
+			for _, url := range tt.down {
+				health.SetDown(url, true)
+			}
+			opts := Options{Health: health, Random: fixedRandom(tt.random)}
+
+			r := httptest.NewRequest(http.MethodGet, tt.entry.Path, nil)
+			got := tt.entry.pickURL(httptest.NewRecorder(), r, opts)
+			if got != tt.want {
+				t.Errorf("pickURL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickURLSticky(t *testing.T) {
+	e := Entry{
+		Path:   "/sticky",
+		URL:    "https://a.example.com",
+		Split:  []Target{{URL: "https://b.example.com"}},
+		Sticky: true,
+	}
+
+	// Picked at random the first time, and pinned by the cookie set
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, e.Path, nil)
+	got := e.pickURL(w, r, Options{Random: fixedRandom(1)})
+	if got != "https://b.example.com" {
+		t.Fatalf("first pickURL() = %s, want https://b.example.com", got)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+
+	r = httptest.NewRequest(http.MethodGet, e.Path, nil)
+	r.AddCookie(cookies[0])
+	got = e.pickURL(httptest.NewRecorder(), r, Options{Random: fixedRandom(0)})
+	if got != "https://b.example.com" {
+		t.Errorf("pickURL() with cookie = %s, want https://b.example.com", got)
+	}
+}
+
+func TestCheckSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   Entry
+		wantErr bool
+	}{
+		{"no split", Entry{Path: "/a", URL: "https://a"}, false},
+		{"weights", Entry{Path: "/a", URL: "https://a", Weight: 2,
+			Split: []Target{{URL: "https://b", Weight: 1}}}, false},
+		{"zero weights", Entry{Path: "/a", URL: "https://a",
+			Split: []Target{{URL: "https://b"}}}, false},
+		{"negative weight", Entry{Path: "/a", URL: "https://a", Weight: -1}, true},
+		{"negative target weight", Entry{Path: "/a", URL: "https://a",
+			Split: []Target{{URL: "https://b", Weight: -2}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.entry.checkSplit()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkSplit() = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStoreHandlerSplit(t *testing.T) {
+	store := NewMemoryStoreFromEntries([]Entry{{
+		Path:  "/docs/*",
+		URL:   "https://a.example.com",
+		Split: []Target{{URL: "https://b.example.com"}},
+	}})
+
+	for random, want := range []string{
+		"https://a.example.com/intro",
+		"https://b.example.com/intro",
+	} {
+		handler := StoreHandlerWithOptions(store, http.NotFoundHandler(),
+			Options{Random: fixedRandom(random)})
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/docs/intro", nil))
+		if got := w.Header().Get("Location"); got != want {
+			t.Errorf("with random %d, Location = %s, want %s", random, got, want)
+		}
+	}
+}
+
+func TestParsedSourceRefusesNegativeWeights(t *testing.T) {
+	yml := []byte(`
+- path: /neg
+  url: https://a.example.com
+  weight: -1
+  split: [{url: https://b.example.com}]
+`)
+	if _, err := ParsedSource("yaml", ParseYAML, yml); err == nil {
+		t.Error("ParsedSource() accepted a negative weight")
+	}
+}
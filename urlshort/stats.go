@@ -0,0 +1,208 @@
+package urlshort
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Distinct referrer hosts kept per path, beyond which hits are counted
+// against otherReferrer so that memory use stays bounded
+const (
+	maxReferrersPerPath = 100
+	otherReferrer       = "other"
+	noReferrer          = "none"
+)
+
+// PathStats holds the usage of a single short path.
+type PathStats struct {
+	Hits        uint64            `json:"hits"`
+	FirstAccess time.Time         `json:"first_access"`
+	LastAccess  time.Time         `json:"last_access"`
+	Referrers   map[string]uint64 `json:"referrers"`   // By referrer host
+	UserAgents  map[string]uint64 `json:"user_agents"` // By agent family
+}
+
+// Stats records the entries matched by the handlers it is hooked into (see
+// OnMatch), by entry key, so that paths matching no entry are never kept.
+// It is safe for concurrent use.
+type Stats struct {
+	mu    sync.Mutex
+	paths map[string]*PathStats
+}
+
+// NewStats returns an empty Stats.
+func NewStats() *Stats {
+	return &Stats{paths: make(map[string]*PathStats)}
+}
+
+// OnMatch records a hit on the matched entry. Set it as (or call it from)
+// the OnMatch hook of the Options of the handlers to record.
+func (s *Stats) OnMatch(r *http.Request, e Entry, dest string) {
+	s.Record(e.Key(), r)
+}
+
+// Record counts a hit on the given path made by the given request.
+func (s *Stats) Record(path string, r *http.Request) {
+	// Work out the details before taking the lock to keep it short
+	now := time.Now()
+	referrer := referrerHost(r.Referer())
+	agent := userAgentFamily(r.UserAgent())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps, ok := s.paths[path]
+	if !ok {
+		ps = &PathStats{
+			FirstAccess: now,
+			Referrers:   make(map[string]uint64),
+			UserAgents:  make(map[string]uint64),
+		}
+		s.paths[path] = ps
+	}
+
+	ps.Hits++
+	ps.LastAccess = now
+	if _, ok = ps.Referrers[referrer]; !ok && len(ps.Referrers) >= maxReferrersPerPath {
+		referrer = otherReferrer
+	}
+	ps.Referrers[referrer]++
+	ps.UserAgents[agent]++
+}
+
+// Snapshot returns a copy of the stats of every path hit so far.
+func (s *Stats) Snapshot() map[string]PathStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := make(map[string]PathStats, len(s.paths))
+	for path, ps := range s.paths {
+		c := *ps
+		c.Referrers = make(map[string]uint64, len(ps.Referrers))
+		for k, v := range ps.Referrers {
+			c.Referrers[k] = v
+		}
+		c.UserAgents = make(map[string]uint64, len(ps.UserAgents))
+		for k, v := range ps.UserAgents {
+			c.UserAgents[k] = v
+		}
+		snap[path] = c
+	}
+	return snap
+}
+
+// JSONHandler returns a handler that responds with the Snapshot as JSON.
+func (s *Stats) JSONHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Snapshot())
+	})
+}
+
+// MetricsHandler returns a handler that responds with the stats in the
+// Prometheus text exposition format.
+func (s *Stats) MetricsHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snap := s.Snapshot()
+
+		paths := make([]string, 0, len(snap))
+		for path := range snap {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		fmt.Fprintln(w, "# HELP urlshort_redirects_total Redirects served per short path.")
+		fmt.Fprintln(w, "# TYPE urlshort_redirects_total counter")
+		for _, path := range paths {
+			fmt.Fprintf(w, "urlshort_redirects_total{path=\"%s\"} %d\n",
+				promEscape(path), snap[path].Hits)
+		}
+
+		fmt.Fprintln(w, "# HELP urlshort_last_access_seconds Unix time of the last redirect per short path.")
+		fmt.Fprintln(w, "# TYPE urlshort_last_access_seconds gauge")
+		for _, path := range paths {
+			fmt.Fprintf(w, "urlshort_last_access_seconds{path=\"%s\"} %d\n",
+				promEscape(path), snap[path].LastAccess.Unix())
+		}
+
+		fmt.Fprintln(w, "# HELP urlshort_referrer_redirects_total Redirects served per short path and referrer host.")
+		fmt.Fprintln(w, "# TYPE urlshort_referrer_redirects_total counter")
+		for _, path := range paths {
+			for _, host := range sortedKeys(snap[path].Referrers) {
+				fmt.Fprintf(w, "urlshort_referrer_redirects_total{path=\"%s\",referrer=\"%s\"} %d\n",
+					promEscape(path), promEscape(host), snap[path].Referrers[host])
+			}
+		}
+
+		fmt.Fprintln(w, "# HELP urlshort_agent_redirects_total Redirects served per short path and user agent family.")
+		fmt.Fprintln(w, "# TYPE urlshort_agent_redirects_total counter")
+		for _, path := range paths {
+			for _, agent := range sortedKeys(snap[path].UserAgents) {
+				fmt.Fprintf(w, "urlshort_agent_redirects_total{path=\"%s\",agent=\"%s\"} %d\n",
+					promEscape(path), promEscape(agent), snap[path].UserAgents[agent])
+			}
+		}
+	})
+}
+
+// referrerHost returns the lower cased host of the given referrer URL
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return noReferrer
+	}
+	u, err := url.Parse(referrer)
+	if err != nil || u.Hostname() == "" {
+		return otherReferrer
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// userAgentFamily returns a coarse family name for the given user agent.
+// Order matters as most browsers claim to be several others as well.
+func userAgentFamily(agent string) string {
+	a := strings.ToLower(agent)
+	switch {
+	case a == "":
+		return "none"
+	case strings.Contains(a, "bot") || strings.Contains(a, "crawl") ||
+		strings.Contains(a, "spider"):
+		return "bot"
+	case strings.HasPrefix(a, "curl/"):
+		return "curl"
+	case strings.HasPrefix(a, "wget/"):
+		return "wget"
+	case strings.Contains(a, "edg/"):
+		return "edge"
+	case strings.Contains(a, "opr/"):
+		return "opera"
+	case strings.Contains(a, "firefox/"):
+		return "firefox"
+	case strings.Contains(a, "chrome/") || strings.Contains(a, "crios/"):
+		return "chrome"
+	case strings.Contains(a, "safari/"):
+		return "safari"
+	default:
+		return "other"
+	}
+}
+
+// promEscape escapes the given string for use as a Prometheus label value
+func promEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// sortedKeys returns the keys of the given map in sorted order
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,32 @@
+package urlshort
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatsRecordsEntryKeys(t *testing.T) {
+	store := NewMemoryStoreFromEntries([]Entry{
+		{Path: "/a", URL: "https://example.com/a"},
+		{Path: "/docs/*", URL: "https://example.com/docs"},
+	})
+	stats := NewStats()
+	fallback := http.RedirectHandler("https://example.com", http.StatusFound)
+	handler := StoreHandlerWithOptions(store, fallback, Options{OnMatch: stats.OnMatch})
+
+	for _, path := range []string{"/a", "/docs/one", "/docs/two", "/nothing", "/else"} {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	snap := stats.Snapshot()
+	want := map[string]uint64{"/a": 1, "/docs/*": 2}
+	if len(snap) != len(want) {
+		t.Errorf("got stats for %d keys, want %d: %v", len(snap), len(want), snap)
+	}
+	for key, hits := range want {
+		if got := snap[key].Hits; got != hits {
+			t.Errorf("hits of %s = %d, want %d", key, got, hits)
+		}
+	}
+}
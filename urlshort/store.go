@@ -0,0 +1,467 @@
+package urlshort
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a single short path along with the URL it redirects to.
+type Entry struct {
+	Path string `json:"path" yaml:"path"`
+	URL  string `json:"url" yaml:"url"`
+
+	// Optional host the entry is served on, any host if not set
+	Host string `json:"host,omitempty" yaml:"host,omitempty"`
+
+	// Optional details shown on the preview page of the entry, which is
+	// shown instead of redirecting if Interstitial is set
+	Description  string     `json:"description,omitempty" yaml:"description,omitempty"`
+	Created      *time.Time `json:"created,omitempty" yaml:"created,omitempty"`
+	Interstitial bool       `json:"interstitial,omitempty" yaml:"interstitial,omitempty"`
+
+	// Optional limits after which the entry no longer redirects
+	ExpiresAt *time.Time `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+	MaxUses   int        `json:"max_uses,omitempty" yaml:"max_uses,omitempty"`
+	Uses      int        `json:"uses,omitempty" yaml:"uses,omitempty"`
+
+	// Optional redirect status (303 if not set) and handling of the
+	// incoming query string (one of the Query constants, drop if not set)
+	Status int    `json:"status,omitempty" yaml:"status,omitempty"`
+	Query  string `json:"query,omitempty" yaml:"query,omitempty"`
+
+	// Optional further destinations requests are split among by weight,
+	// along with URL (whose weight is Weight, 1 if not set), pinning each
+	// client to one with a cookie if Sticky is set, and destinations tried
+	// in order if those are all marked down (see Health)
+	Weight    int      `json:"weight,omitempty" yaml:"weight,omitempty"`
+	Split     []Target `json:"split,omitempty" yaml:"split,omitempty"`
+	Sticky    bool     `json:"sticky,omitempty" yaml:"sticky,omitempty"`
+	Fallbacks []string `json:"fallbacks,omitempty" yaml:"fallbacks,omitempty"`
+}
+
+// Store holds the short path to URL mappings served by StoreHandler.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Lookup returns the entry with the given key (see Entry.Key), if any
+	Lookup(key string) (Entry, bool)
+	// Put adds the entry, replacing any existing entry with its key
+	Put(e Entry) error
+	// Delete removes the entry with the given key (no-op if absent)
+	Delete(key string) error
+	// List returns all the entries sorted by path, then host
+	List() []Entry
+}
+
+// Key returns the key of the entry in a Store: its path, preceded by its
+// host if it has one (as in "go.example.com/some-path").
+func (e Entry) Key() string {
+	return EntryKey(e.Host, e.Path)
+}
+
+// EntryKey returns the Store key of an entry with the given host and path.
+func EntryKey(host, path string) string {
+	return normalizeHost(host) + path
+}
+
+// SplitKey returns the host (if any) and path of the given Store key, as
+// in the entry keys of flat and CSV files.
+func SplitKey(key string) (string, string) {
+	if i := strings.Index(key, "/"); i > 0 {
+		return key[:i], key[i:]
+	}
+	return "", key
+}
+
+// normalizeHost returns the host lower cased and without any port or
+// trailing dot, with the wildcard host "*" turned into "" (any host)
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	host = strings.TrimSuffix(host, ".")
+	if host == "*" {
+		return ""
+	}
+	return host
+}
+
+// ErrEmptyEntry is returned when storing an entry without a path or URL.
+var ErrEmptyEntry = errors.New("urlshort: entry needs both path and url")
+
+////
+// In-memory store
+////
+
+// MemoryStore is a Store that keeps its mappings only in process memory.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	entries map[string]Entry
+	routes  []route // Compiled pattern entries, most specific first
+}
+
+// NewMemoryStore returns a MemoryStore seeded with the given mapping of
+// paths to urls (which may be nil).
+func NewMemoryStore(pathsToUrls map[string]string) *MemoryStore {
+	s := &MemoryStore{entries: make(map[string]Entry, len(pathsToUrls))}
+	for path, url := range pathsToUrls {
+		s.entries[path] = Entry{Path: path, URL: url}
+	}
+	s.indexRoutes()
+	return s
+}
+
+func (s *MemoryStore) Lookup(key string) (Entry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.entries[key]
+	return e, ok
+}
+
+func (s *MemoryStore) Put(e Entry) error {
+	e, err := prepareEntry(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[e.Key()] = e
+	if isPattern(e.Path) {
+		s.indexRoutes()
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	if isPattern(key) {
+		s.indexRoutes()
+	}
+	return nil
+}
+
+// prepareEntry returns the entry as stored, with its host normalized, or
+// an error if it cannot be stored
+func prepareEntry(e Entry) (Entry, error) {
+	if e.Path == "" || e.URL == "" {
+		return e, ErrEmptyEntry
+	}
+	if err := e.checkRedirect(); err != nil {
+		return e, err
+	}
+	if isPattern(e.Path) {
+		if _, err := compileRoute(e); err != nil {
+			return e, err
+		}
+	}
+	e.Host = normalizeHost(e.Host)
+	return e, nil
+}
+
+// listWith returns the entries of the store as they would be with the
+// given entry put, if not nil, and the entry with the given key deleted
+func (s *MemoryStore) listWith(put *Entry, deleteKey string) []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m := make(map[string]Entry, len(s.entries)+1)
+	for k, e := range s.entries {
+		m[k] = e
+	}
+	delete(m, deleteKey)
+	if put != nil {
+		m[put.Key()] = *put
+	}
+	return sortedEntries(m)
+}
+
+// Match returns the most specific pattern entry matching the given host
+// and path, along with the URL it redirects the path to.
+func (s *MemoryStore) Match(host, path string) (Entry, string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return matchRoutes(s.routes, host, path)
+}
+
+// indexRoutes compiles the pattern entries afresh. Must be called with the
+// lock held (or before the store is shared).
+func (s *MemoryStore) indexRoutes() {
+	s.routes = compileRoutes(sortedEntries(s.entries))
+}
+
+func (s *MemoryStore) List() []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedEntries(s.entries)
+}
+
+// NewMemoryStoreFromEntries returns a MemoryStore holding the
+// given entries, skipping any entry that lacks either a path or
+// a url, or whose redirect status, query handling or weights are
+// not supported. Later entries replace earlier ones with the same
+// path.
+func NewMemoryStoreFromEntries(entries []Entry) *MemoryStore {
+	s := &MemoryStore{entries: make(map[string]Entry, len(entries))}
+	for _, e := range entries {
+		if len(e.Path) != 0 && len(e.URL) != 0 && e.checkRedirect() == nil {
+			e.Host = normalizeHost(e.Host)
+			s.entries[e.Key()] = e
+		}
+	}
+	s.indexRoutes()
+	return s
+}
+
+// sortedEntries returns the values of the given map sorted by path
+func sortedEntries(m map[string]Entry) []Entry {
+	list := make([]Entry, 0, len(m))
+	for _, e := range m {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Path != list[j].Path {
+			return list[i].Path < list[j].Path
+		}
+		return list[i].Host < list[j].Host
+	})
+	return list
+}
+
+////
+// JSON file store
+////
+
+// JSONFileStore is a Store kept in memory and written out in full to a JSON
+// file (as a list of entries) after every change.
+type JSONFileStore struct {
+	MemoryStore
+	fileName string
+	writeMu  sync.Mutex // Serializes rewrites of the file
+}
+
+// NewJSONFileStore loads the entries in the given JSON file, which need not
+// exist yet, and returns a store that saves every change back to it.
+// Entries in the file that could not be put in the store are skipped.
+func NewJSONFileStore(fileName string) (*JSONFileStore, error) {
+	s := &JSONFileStore{
+		MemoryStore: MemoryStore{entries: make(map[string]Entry)},
+		fileName:    fileName,
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Entry
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("error parsing store file %s: %v", fileName, err)
+	}
+	for _, e := range list {
+		// Keyed by normalized host like the entries put, skipping any
+		// that could not be put, as when replaying a LogStore
+		if e, err := prepareEntry(e); err == nil {
+			s.entries[e.Key()] = e
+		}
+	}
+	s.indexRoutes()
+	return s, nil
+}
+
+// Put saves the file with the entry and only then changes the entries in
+// memory, so the two never differ.
+func (s *JSONFileStore) Put(e Entry) error {
+	e, err := prepareEntry(e)
+	if err != nil {
+		return err
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err = s.save(s.listWith(&e, "")); err != nil {
+		return err
+	}
+	return s.MemoryStore.Put(e)
+}
+
+// Delete saves the file without the entry and only then changes the
+// entries in memory, so the two never differ.
+func (s *JSONFileStore) Delete(key string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.save(s.listWith(nil, key)); err != nil {
+		return err
+	}
+	return s.MemoryStore.Delete(key)
+}
+
+// save writes the given entries to the store file
+func (s *JSONFileStore) save(entries []Entry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.fileName, data)
+}
+
+// writeFileAtomic writes the data to a temporary file and renames it over
+// the named file (keeping its permissions, if it exists), so that a crash
+// never leaves a partially written file behind
+func writeFileAtomic(fileName string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(fileName), ".urlshort-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if info, err := os.Stat(fileName); err == nil {
+		if err = os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), fileName)
+}
+
+////
+// Append-only log store
+////
+
+// Operations recorded in the log of a LogStore
+const (
+	logOpPut    = "put"
+	logOpDelete = "delete"
+)
+
+// logRecord is a single line (JSON) of a LogStore's log file
+type logRecord struct {
+	Op    string `json:"op"`
+	Entry Entry  `json:"entry"`
+}
+
+// LogStore is a Store kept in memory whose changes are appended to a log
+// file. The log is replayed on open, so the mappings survive restarts.
+type LogStore struct {
+	MemoryStore
+	mu   sync.Mutex // Serializes appends to the log
+	file *os.File
+}
+
+// OpenLogStore replays the given log file (creating it if necessary) and
+// returns a store that appends every change to it. Close must be called
+// once the store is no longer needed.
+func OpenLogStore(fileName string) (*LogStore, error) {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &LogStore{
+		MemoryStore: MemoryStore{entries: make(map[string]Entry)},
+		file:        file,
+	}
+
+	// Replay the log to rebuild the mappings
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		var rec logRecord
+		if err = json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error in log %s at line %d: %v",
+				fileName, lineNum, err)
+		}
+
+		switch rec.Op {
+		case logOpPut:
+			// Skipping entries that could not be stored now, which
+			// older versions logged before checking them
+			if e, err := prepareEntry(rec.Entry); err == nil {
+				s.entries[e.Key()] = e
+			}
+		case logOpDelete:
+			delete(s.entries, rec.Entry.Key())
+		default:
+			file.Close()
+			return nil, fmt.Errorf("unknown operation '%s' in log %s at line %d",
+				rec.Op, fileName, lineNum)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	s.indexRoutes()
+	return s, nil
+}
+
+// Put appends the entry to the log once it is known to be valid.
+func (s *LogStore) Put(e Entry) error {
+	e, err := prepareEntry(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(logRecord{Op: logOpPut, Entry: e}); err != nil {
+		return err
+	}
+	return s.MemoryStore.Put(e)
+}
+
+func (s *LogStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.Lookup(key)
+	if !ok {
+		return nil
+	}
+	err := s.append(logRecord{Op: logOpDelete, Entry: Entry{Host: e.Host, Path: e.Path}})
+	if err != nil {
+		return err
+	}
+	return s.MemoryStore.Delete(key)
+}
+
+// Close closes the underlying log file
+func (s *LogStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// append writes the record to the log and syncs it to disk
+func (s *LogStore) append(rec logRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err = s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
@@ -0,0 +1,82 @@
+package urlshort
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogStoreRefusedEntryNotLogged(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "links.log")
+	s, err := OpenLogStore(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Put(Entry{Path: "/good", URL: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Put(Entry{Path: "/bad", URL: "https://example.com", Status: 200}); err == nil {
+		t.Error("Put() accepted status 200")
+	}
+	s.Close()
+
+	s, err = OpenLogStore(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, ok := s.Lookup("/bad"); ok {
+		t.Error("refused entry came back on replay")
+	}
+	if _, ok := s.Lookup("/good"); !ok {
+		t.Error("stored entry missing on replay")
+	}
+}
+
+func TestJSONFileStoreFailedSaveKeepsMemory(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewJSONFileStore(filepath.Join(dir, "links.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Put(Entry{Path: "/a", URL: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving fails once the file's directory is gone
+	s.fileName = filepath.Join(dir, "missing", "links.json")
+	if err = s.Put(Entry{Path: "/b", URL: "https://example.com"}); err == nil {
+		t.Fatal("Put() succeeded without saving")
+	}
+	if _, ok := s.Lookup("/b"); ok {
+		t.Error("entry that failed to save is in memory")
+	}
+	if err = s.Delete("/a"); err == nil {
+		t.Fatal("Delete() succeeded without saving")
+	}
+	if _, ok := s.Lookup("/a"); !ok {
+		t.Error("entry whose deletion failed to save is gone from memory")
+	}
+}
+
+func TestJSONFileStoreNormalizesLoadedHosts(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "links.json")
+	data := `[
+		{"host": "GO.Example.com:443", "path": "/a", "url": "https://example.com"},
+		{"path": "/bad", "url": "https://example.com", "status": 200}
+	]`
+	if err := ioutil.WriteFile(fileName, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewJSONFileStore(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.Lookup(EntryKey("go.example.com", "/a")); !ok {
+		t.Errorf("entry not found by its normalized host: %v", s.List())
+	}
+	if _, ok := s.Lookup("/bad"); ok {
+		t.Error("entry with status 200 loaded")
+	}
+}
@@ -6,7 +6,8 @@ package urlshort
 
 import (
 	"net/http"
-	"gopkg.in/yaml.v2"
+	"sync"
+	"time"
 )
 
 // MapHandler will return an http.HandlerFunc (which also
@@ -16,16 +17,133 @@ import (
 // If the path is not provided in the map, then the fallback
 // http.Handler will be called instead.
 func MapHandler(pathsToUrls map[string]string, fallback http.Handler) http.HandlerFunc {
+	return StoreHandler(NewMemoryStore(pathsToUrls), fallback)
+}
+
+// StoreHandler will return an http.HandlerFunc (which also
+// implements http.Handler) that will attempt to map any
+// paths to their corresponding URL as found in the given
+// Store. If the path is not in the store, then the fallback
+// http.Handler will be called instead.
+//
+// Besides exact paths, entries may have pattern paths with
+// parameters substituted into their URL, such as
+//
+//     /gh/{user}/{repo} -> https://github.com/{user}/{repo}
+//
+// or prefix paths whose URL gets the rest of the path added,
+// such as
+//
+//     /docs/* -> https://docs.example.com (so /docs/a/b goes
+//                to https://docs.example.com/a/b)
+//
+// An exact path always wins, then patterns are tried from
+// the most to the least specific.
+//
+// Entries with a Host are only served for requests to that
+// host (ignoring case and port), and are preferred over
+// entries without one, which are served for any host.
+//
+// Adding "+" to a short path, or a preview=1 query parameter,
+// shows a page with the destination and details of the entry
+// instead of redirecting. Entries can ask for that page to
+// always be shown by setting Interstitial.
+//
+// Redirects are made with status 303 See Other and without
+// the incoming query string, unless the entry chooses its
+// own Status and Query handling.
+//
+// Entries may split requests among several destinations by
+// weight, and fall back on others when those are down (see
+// Options).
+//
+// Since the store is consulted on every request, changes
+// made to it are seen by the handler right away.
+func StoreHandler(store Store, fallback http.Handler) http.HandlerFunc {
+	return StoreHandlerWithOptions(store, fallback, Options{})
+}
+
+// Options changes the behavior of a handler from that of
+// StoreHandler. The zero value gives the default behavior.
+type Options struct {
+	// Send requests for expired or used up entries to the
+	// fallback handler instead of responding 410 Gone
+	GoneToFallback bool
+
+	// Refuse entries that fail Validate, returning a
+	// *ValidationError, rather than skipping the bad ones
+	RefuseInvalid bool
+
+	// Hosts the handler is served on, used by validation to
+	// spot entries redirecting to other entries
+	OwnHosts []string
+
+	// Destinations marked down, avoided by entries that split
+	// requests or have fallbacks (none are if nil)
+	Health *Health
+
+	// Source of random numbers in [0, n) for picking among
+	// split destinations (math/rand's Intn if nil)
+	Random func(n int) int
+
+	// Called with each request served by an entry and the
+	// URL it is sent (or previews sending) to, as for logging
+	OnMatch func(r *http.Request, e Entry, dest string)
+
+	// Codes CreateHandlerWithOptions never hands out, such as
+	// the first path segments of the server's own endpoints
+	ReservedCodes []string
+}
+
+// StoreHandlerWithOptions is the same as StoreHandler but
+// with its behavior adjusted by the given options.
+func StoreHandlerWithOptions(store Store, fallback http.Handler, opts Options) http.HandlerFunc {
+	// Serializes use counting of entries with a use limit
+	var usesMu sync.Mutex
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		url, ok := pathsToUrls[(*r).URL.Path]
-		if ok {
-			http.Redirect(w, r, url, http.StatusSeeOther)
-		} else {
+		entry, dest, path, preview, ok := resolveRequest(store, r)
+		if !ok {
 			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		// Previews asked for do not count as uses, unlike those of
+		// entries always shown through their preview page
+		alive := !entry.Dead(time.Now())
+		if alive && !preview && entry.MaxUses > 0 {
+			alive = takeUse(store, &usesMu, entry.Key())
+		}
+
+		if alive {
+			if url := entry.pickURL(w, r, opts); url != entry.URL {
+				dest = entry.expandURL(url, path)
+			}
+			dest = entry.applyQuery(dest, destQuery(r))
+		}
+
+		switch {
+		case alive && (preview || entry.Interstitial):
+			opts.matched(r, entry, dest)
+			servePreview(w, r, entry, dest)
+		case alive:
+			opts.matched(r, entry, dest)
+			http.Redirect(w, r, dest, entry.redirectStatus())
+		case opts.GoneToFallback:
+			fallback.ServeHTTP(w, r)
+		default:
+			http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
 		}
 	})
 }
 
+// matched calls the OnMatch hook of the options, if any
+func (opts Options) matched(r *http.Request, e Entry, dest string) {
+	if opts.OnMatch != nil {
+		opts.OnMatch(r, e, dest)
+	}
+}
+
 // YAMLHandler will parse the provided YAML and then return
 // an http.HandlerFunc (which also implements http.Handler)
 // that will attempt to map any paths to their corresponding
@@ -38,38 +156,71 @@ func MapHandler(pathsToUrls map[string]string, fallback http.Handler) http.Handl
 //       url: https://www.some-url.com/demo
 //
 // The only errors that can be returned all related to having
-// invalid YAML data.
+// invalid YAML data, including keys other than path and url.
 //
 // See MapHandler to create a similar http.HandlerFunc via
 // a mapping of paths to urls.
 func YAMLHandler(yml []byte, fallback http.Handler) (http.HandlerFunc, error) {
+	return parsedHandler(ParseYAML, yml, fallback)
+}
 
-	type Data []map[string]string
+// YAMLHandlerWithOptions is the same as YAMLHandler but with
+// the behavior of the handler adjusted by the given options.
+func YAMLHandlerWithOptions(yml []byte, fallback http.Handler,
+	opts Options) (http.HandlerFunc, error) {
 
-	var data Data
-	err := yaml.Unmarshal(yml, &data)
+	if opts.RefuseInvalid {
+		problems, err := ValidateYAML(yml, opts.OwnHosts...)
+		if err != nil {
+			return nil, err
+		}
+		if len(problems) > 0 {
+			return nil, &ValidationError{Problems: problems}
+		}
+	}
+
+	entries, err := ParseYAML(yml)
 	if err != nil {
 		return nil, err
 	}
+	if err = checkEntries(entries); err != nil {
+		return nil, err
+	}
+	return StoreHandlerWithOptions(NewMemoryStoreFromEntries(entries),
+		fallback, opts), nil
+}
 
-	pathsToUrls := make(map[string]string)
+// JSONHandler is the same as YAMLHandler except that the
+// entries are provided as JSON in the format:
+//
+//     [{"path": "/some-path", "url": "https://www.some-url.com/demo"}]
+func JSONHandler(data []byte, fallback http.Handler) (http.HandlerFunc, error) {
+	return parsedHandler(ParseJSON, data, fallback)
+}
 
-	// Get yaml data into a plain map
-	for _, ymlMap := range data {
-		k := ymlMap["path"]
-		v := ymlMap["url"]
+// FlatHandler is the same as YAMLHandler except that the
+// entries are provided one per line in the format:
+//
+//     /some-path = https://www.some-url.com/demo
+//
+// See ParseFlat for details of the format.
+func FlatHandler(data []byte, fallback http.Handler) (http.HandlerFunc, error) {
+	return parsedHandler(ParseFlat, data, fallback)
+}
 
-		if len(k) != 0 && len(v) != 0 {
-			pathsToUrls[k] = v
-		}
+// parsedHandler parses the given data using the given parse
+// function and returns a handler serving the entries found,
+// skipping any entry that lacks either a path or a url
+func parsedHandler(parse func([]byte) ([]Entry, error), data []byte,
+	fallback http.Handler) (http.HandlerFunc, error) {
+
+	entries, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkEntries(entries); err != nil {
+		return nil, err
 	}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		url, ok := pathsToUrls[(*r).URL.Path]
-		if ok {
-			http.Redirect(w, r, url, http.StatusSeeOther)
-		} else {
-			fallback.ServeHTTP(w, r)
-		}
-	}), nil
+	return StoreHandler(NewMemoryStoreFromEntries(entries), fallback), nil
 }
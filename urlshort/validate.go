@@ -0,0 +1,229 @@
+package urlshort
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Kinds of problems reported by Validate
+const (
+	ProblemMissing   = "missing"    // No path or no url
+	ProblemBadPath   = "bad-path"   // Path not starting with '/' or bad pattern
+	ProblemDuplicate = "duplicate"  // Path already used by an earlier entry
+	ProblemBadURL    = "bad-url"    // URL that is malformed or not absolute
+	ProblemScheme    = "scheme"     // URL scheme other than http or https
+	ProblemOption    = "bad-option" // Unsupported redirect status, query or weight
+	ProblemChain     = "chain"      // URL pointing at another short path
+	ProblemLoop      = "loop"       // Short paths redirecting in a circle
+)
+
+// Problem is a single issue found with an entry by Validate.
+type Problem struct {
+	Entry   int    `json:"entry"`          // Index of the entry
+	Line    int    `json:"line,omitempty"` // Line of the entry, if known
+	Path    string `json:"path"`
+	Kind    string `json:"kind"` // One of the Problem constants
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", p.Line, p.Kind, p.Message)
+	}
+	return fmt.Sprintf("entry %d: %s: %s", p.Entry+1, p.Kind, p.Message)
+}
+
+// ValidationError is returned by handlers that refuse entries which fail
+// validation (see Options).
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.String()
+	}
+	return fmt.Sprintf("%d problem(s) with entries:\n  %s", len(e.Problems),
+		strings.Join(msgs, "\n  "))
+}
+
+// Validate checks the given entries and returns the problems found, in
+// entry order. URLs on any of the given hosts (the hosts the entries are
+// served on), on the host of any entry, or without a host are taken to
+// point back at the entries, and are reported if they do.
+func Validate(entries []Entry, hosts ...string) []Problem {
+	var problems []Problem
+	add := func(i int, kind, format string, args ...interface{}) {
+		problems = append(problems, Problem{
+			Entry:   i,
+			Path:    entries[i].Path,
+			Kind:    kind,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	ownHosts := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		ownHosts[normalizeHost(h)] = true
+	}
+	for _, e := range entries {
+		if h := normalizeHost(e.Host); h != "" {
+			ownHosts[h] = true
+		}
+	}
+
+	firstUse := make(map[string]int) // Key to index of first entry using it
+	lastUse := make(map[string]int)  // And of the last, which is the one served
+	for i, e := range entries {
+		if e.Path == "" || e.URL == "" {
+			add(i, ProblemMissing, "entry needs both path and url")
+			continue
+		}
+
+		if !strings.HasPrefix(e.Path, "/") {
+			add(i, ProblemBadPath, "path '%s' does not start with '/'", e.Path)
+		} else if isPattern(e.Path) {
+			if _, err := compileRoute(e); err != nil {
+				add(i, ProblemBadPath, "%v", err)
+			}
+		}
+
+		key := e.Key()
+		if first, ok := firstUse[key]; ok {
+			add(i, ProblemDuplicate, "path '%s' already used by entry %d",
+				key, first+1)
+		} else {
+			firstUse[key] = i
+		}
+		lastUse[key] = i
+
+		for _, dest := range e.allURLs() {
+			if u, err := url.Parse(dest); err != nil {
+				add(i, ProblemBadURL, "url '%s' is malformed: %v", dest, err)
+			} else if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+				// Before the host check, as mailto: and the like have none
+				add(i, ProblemScheme, "url '%s' has unsupported scheme '%s'",
+					dest, u.Scheme)
+			} else if u.Scheme == "" || u.Host == "" {
+				add(i, ProblemBadURL, "url '%s' is not absolute", dest)
+			}
+		}
+
+		if err := e.checkRedirect(); err != nil {
+			add(i, ProblemOption, "%v", err)
+		}
+	}
+
+	// Look for entries redirecting to other entries
+	store := NewMemoryStoreFromEntries(entries)
+	for i, e := range entries {
+		if e.Path == "" || e.URL == "" || lastUse[e.Key()] != i {
+			continue
+		}
+
+		seen := []string{e.Key()}
+		for next := e; ; {
+			host, target, ok := chainTarget(next, ownHosts)
+			if !ok {
+				break
+			}
+			next, _, ok = resolve(store, host, target)
+			if !ok {
+				break
+			}
+			seen = append(seen, next.Key())
+
+			if next.Key() == e.Key() {
+				add(i, ProblemLoop, "redirect loop %s", strings.Join(seen, " -> "))
+				break
+			}
+			if contains(seen[:len(seen)-1], next.Key()) {
+				break // Loop not through this entry, reported for its members
+			}
+		}
+		if len(seen) > 1 && seen[len(seen)-1] != e.Key() {
+			add(i, ProblemChain, "redirect chain %s", strings.Join(seen, " -> "))
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Entry < problems[j].Entry
+	})
+	return problems
+}
+
+// ValidateYAML parses the given YAML as ParseYAML does and validates the
+// entries as Validate does, filling in the line of each problem.
+func ValidateYAML(yml []byte, hosts ...string) ([]Problem, error) {
+	entries, err := ParseYAML(yml)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := Validate(entries, hosts...)
+	lines := yamlItemLines(yml)
+	for i := range problems {
+		if problems[i].Entry < len(lines) {
+			problems[i].Line = lines[problems[i].Entry]
+		}
+	}
+	return problems, nil
+}
+
+// chainTarget returns the host and path the entry's URL points to if that
+// URL is on one of the given hosts or has no host at all (taking the host
+// to be the entry's own)
+func chainTarget(e Entry, ownHosts map[string]bool) (string, string, bool) {
+	u, err := url.Parse(e.URL)
+	if err != nil || u.Path == "" {
+		return "", "", false
+	}
+	if u.Host == "" {
+		return e.Host, u.Path, true
+	}
+	if !ownHosts[normalizeHost(u.Host)] {
+		return "", "", false
+	}
+	return u.Host, u.Path, true
+}
+
+// yamlItemLines returns the line number of each item of the top level list
+// in the given YAML. Only the text is scanned, which is good enough for the
+// lists of entries parsed by ParseYAML.
+func yamlItemLines(yml []byte) []int {
+	var lines []int
+	indent := -1 // Of the top level items, once seen
+
+	scanner := bufio.NewScanner(bytes.NewReader(yml))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		text := scanner.Text()
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed != "-" && !strings.HasPrefix(trimmed, "- ") {
+			continue
+		}
+
+		n := len(text) - len(trimmed)
+		if indent < 0 {
+			indent = n
+		}
+		if n == indent {
+			lines = append(lines, lineNum)
+		}
+	}
+	return lines
+}
+
+// contains returns whether the list has the given string
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package urlshort
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	entries := []Entry{
+		{Path: "/ok", URL: "https://example.com"},
+		{Path: "/no-url"},
+		{Path: "relative", URL: "https://example.com"},
+		{Path: "/ok", URL: "https://example.com/again"},
+		{Path: "/not-absolute", URL: "example.com/page"},
+		{Path: "/no-scheme", URL: "//example.com/page"},
+		{Path: "/scheme", URL: "ftp://example.com/file"},
+		{Path: "/fallback-scheme", URL: "https://example.com", Fallbacks: []string{"javascript:alert(1)"}},
+		{Path: "/status", URL: "https://example.com", Status: 200},
+		{Path: "/chain", URL: "https://go.example.com/ok"},
+		{Path: "/chain-2", URL: "/chain"},
+		{Path: "/loop-a", URL: "/loop-b"},
+		{Path: "/loop-b", URL: "https://go.example.com/loop-a"},
+		{Path: "/other-host", URL: "https://elsewhere.example.com/ok"},
+		{Host: "links.example.com", Path: "/ok", URL: "https://example.com"},
+		{Path: "/hosted-chain", URL: "https://links.example.com/ok"},
+	}
+	want := []struct {
+		entry   int
+		kind    string
+		message string
+	}{
+		{1, ProblemMissing, "entry needs both path and url"},
+		{2, ProblemBadPath, "path 'relative' does not start with '/'"},
+		{3, ProblemDuplicate, "path '/ok' already used by entry 1"},
+		{4, ProblemBadURL, "url 'example.com/page' is not absolute"},
+		{5, ProblemBadURL, "url '//example.com/page' is not absolute"},
+		{6, ProblemScheme, "url 'ftp://example.com/file' has unsupported scheme 'ftp'"},
+		{7, ProblemScheme, "url 'javascript:alert(1)' has unsupported scheme 'javascript'"},
+		{8, ProblemOption, "entry /status: unsupported redirect status 200"},
+		{9, ProblemChain, "redirect chain /chain -> /ok"},
+		{10, ProblemBadURL, "url '/chain' is not absolute"},
+		{10, ProblemChain, "redirect chain /chain-2 -> /chain -> /ok"},
+		{11, ProblemBadURL, "url '/loop-b' is not absolute"},
+		{11, ProblemLoop, "redirect loop /loop-a -> /loop-b -> /loop-a"},
+		{12, ProblemLoop, "redirect loop /loop-b -> /loop-a -> /loop-b"},
+		{15, ProblemChain, "redirect chain /hosted-chain -> links.example.com/ok"},
+	}
+
+	problems := Validate(entries, "go.example.com")
+	if len(problems) != len(want) {
+		t.Errorf("Validate() found %d problems, want %d:", len(problems), len(want))
+		for _, p := range problems {
+			t.Log(p)
+		}
+	}
+	for i := 0; i < len(problems) && i < len(want); i++ {
+		p, w := problems[i], want[i]
+		if p.Entry != w.entry || p.Kind != w.kind || p.Message != w.message ||
+			p.Path != entries[w.entry].Path {
+			t.Errorf("problem %d = %+v, want entry %d %s: %s", i, p, w.entry, w.kind, w.message)
+		}
+	}
+}
+
+func TestValidateYAMLLines(t *testing.T) {
+	tests := []struct {
+		name string
+		yml  string
+		want []Problem
+	}{
+		{"list", `
+# Links
+- path: /a
+  url: https://example.com
+  fallbacks:
+  - https://mirror.example.com
+
+- path: /a
+  url: https://example.com/2
+-   path: /b
+    url: mailto:someone@example.com
+`, []Problem{
+			{Entry: 1, Line: 8, Path: "/a", Kind: ProblemDuplicate,
+				Message: "path '/a' already used by entry 1"},
+			{Entry: 2, Line: 10, Path: "/b", Kind: ProblemScheme,
+				Message: "url 'mailto:someone@example.com' has unsupported scheme 'mailto'"},
+		}},
+		{"grouped by host", `go.example.com:
+  - path: /a
+    url: https://example.com
+  - path: /b
+    url: /a
+"*":
+  - path: /a
+    url: https://example.com
+  - path: /c
+    url: relative
+`, []Problem{
+			{Entry: 1, Line: 4, Path: "/b", Kind: ProblemBadURL,
+				Message: "url '/a' is not absolute"},
+			{Entry: 1, Line: 4, Path: "/b", Kind: ProblemChain,
+				Message: "redirect chain go.example.com/b -> go.example.com/a"},
+			{Entry: 3, Line: 9, Path: "/c", Kind: ProblemBadURL,
+				Message: "url 'relative' is not absolute"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateYAML([]byte(tt.yml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateYAML() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ValidateYAML([]byte("- path: /a\n  uri: https://example.com\n")); err == nil {
+		t.Error("ValidateYAML() accepted an unknown key")
+	}
+}
+
+func TestRefuseInvalid(t *testing.T) {
+	yml := []byte(`
+- path: /a
+  url: https://example.com
+- path: /a
+  url: https://example.com/2
+`)
+	if _, err := YAMLHandlerWithOptions(yml, http.NotFoundHandler(), Options{}); err != nil {
+		t.Errorf("YAMLHandlerWithOptions() without RefuseInvalid error: %v", err)
+	}
+
+	_, err := YAMLHandlerWithOptions(yml, http.NotFoundHandler(),
+		Options{RefuseInvalid: true})
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("YAMLHandlerWithOptions() error = %v, want a *ValidationError", err)
+	}
+	want := []Problem{{Entry: 1, Line: 4, Path: "/a", Kind: ProblemDuplicate,
+		Message: "path '/a' already used by entry 1"}}
+	if !reflect.DeepEqual(verr.Problems, want) {
+		t.Errorf("problems = %+v, want %+v", verr.Problems, want)
+	}
+
+	// Chains count as problems only for URLs on the given hosts
+	chained := []byte(`
+- path: /a
+  url: https://example.com
+- path: /b
+  url: https://go.example.com/a
+`)
+	if _, err := YAMLHandlerWithOptions(chained, http.NotFoundHandler(),
+		Options{RefuseInvalid: true}); err != nil {
+		t.Errorf("chain to another host refused: %v", err)
+	}
+	if _, err := YAMLHandlerWithOptions(chained, http.NotFoundHandler(),
+		Options{RefuseInvalid: true, OwnHosts: []string{"go.example.com"}}); err == nil {
+		t.Error("chain on an own host accepted")
+	}
+}